@@ -0,0 +1,145 @@
+package gptscript
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Budget limits the resources a run can use. The run is aborted when any of the limits is exceeded, and its error is
+// an ErrBudgetExceeded. Limits that are zero are not enforced. The tokens and tool calls are counted from the run's
+// events, which also have the ID of the run to abort and its output so far, so the server is asked for them when any
+// limit is set, even if IncludeEvents isn't.
+type Budget struct {
+	// MaxTokens is the maximum number of tokens, prompt and completion, used by all the calls of the run.
+	MaxTokens int
+	// MaxToolCalls is the maximum number of calls to tools made by the run, not counting the call to the entry tool or
+	// the calls to model providers and credential tools, which the run makes on its own.
+	MaxToolCalls int
+	// MaxDuration is the maximum wall time of the run.
+	MaxDuration time.Duration
+}
+
+func (b Budget) enabled() bool {
+	return b.MaxTokens > 0 || b.MaxToolCalls > 0 || b.MaxDuration > 0
+}
+
+// BudgetLimit is a limit of a Budget.
+type BudgetLimit string
+
+const (
+	BudgetLimitTokens    BudgetLimit = "tokens"
+	BudgetLimitToolCalls BudgetLimit = "toolCalls"
+	BudgetLimitDuration  BudgetLimit = "duration"
+)
+
+// budgetError is the error of a run that exceeded its budget.
+type budgetError struct {
+	limit   BudgetLimit
+	message string
+}
+
+func (e budgetError) Error() string {
+	return "budget exceeded: " + e.message
+}
+
+// budgetWatcher checks the usage of a run against its budget, and aborts the run the first time the budget is exceeded.
+type budgetWatcher struct {
+	budget Budget
+	abort  func(error)
+
+	lock  sync.Mutex
+	err   error
+	timer *time.Timer
+}
+
+// newBudgetWatcher starts watching the budget of a run, calling abort when it is exceeded. It returns nil if the budget
+// doesn't have any limits.
+func newBudgetWatcher(budget Budget, abort func(error)) *budgetWatcher {
+	if !budget.enabled() {
+		return nil
+	}
+
+	w := &budgetWatcher{budget: budget, abort: abort}
+	if budget.MaxDuration > 0 {
+		w.timer = time.AfterFunc(budget.MaxDuration, func() {
+			w.exceeded(budgetError{limit: BudgetLimitDuration, message: fmt.Sprintf("ran for longer than %s", budget.MaxDuration)})
+		})
+	}
+
+	return w
+}
+
+// check compares the usage of the calls so far against the budget.
+func (w *budgetWatcher) check(calls CallFrames) {
+	if w == nil {
+		return
+	}
+
+	var tokens, toolCalls int
+	for _, call := range calls {
+		tokens += call.Usage.TotalTokens
+		if call.ParentID != "" && call.ToolCategory != ProviderToolCategory && call.ToolCategory != CredentialToolCategory {
+			toolCalls++
+		}
+	}
+
+	if w.budget.MaxTokens > 0 && tokens > w.budget.MaxTokens {
+		w.exceeded(budgetError{limit: BudgetLimitTokens, message: fmt.Sprintf("used %d tokens of %d", tokens, w.budget.MaxTokens)})
+	} else if w.budget.MaxToolCalls > 0 && toolCalls > w.budget.MaxToolCalls {
+		w.exceeded(budgetError{limit: BudgetLimitToolCalls, message: fmt.Sprintf("made %d tool calls of %d", toolCalls, w.budget.MaxToolCalls)})
+	}
+}
+
+func (w *budgetWatcher) exceeded(err budgetError) {
+	w.lock.Lock()
+	if w.err != nil {
+		w.lock.Unlock()
+		return
+	}
+	w.err = err
+	w.lock.Unlock()
+
+	slog.Debug("aborting run", "error", err)
+	w.abort(err)
+}
+
+// Err returns the error for the exceeded budget, if any.
+func (w *budgetWatcher) Err() error {
+	if w == nil {
+		return nil
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.err
+}
+
+func (w *budgetWatcher) stop() {
+	if w != nil && w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+// abortForBudget asks the SDK server to abort the run, so that it ends with the output so far. If the run hasn't
+// started yet, or the server can't be asked, then the run is canceled instead.
+func (r *Run) abortForBudget(ctx context.Context, cause error) {
+	r.callsLock.RLock()
+	id := r.id
+	r.callsLock.RUnlock()
+
+	if id == "" {
+		r.cancel(cause)
+		return
+	}
+
+	go func() {
+		// The abort is sent like GPTScript.AbortRun, but with the URL, client, and token of the run.
+		if _, _, err := sendCommand(ctx, r.opts.GlobalOptions, r.client, r.opts.Token, "abort/"+id, (map[string]any)(nil)); err != nil {
+			slog.Debug("failed to abort run", "run", id, "error", err)
+			r.cancel(cause)
+		}
+	}()
+}
@@ -0,0 +1,197 @@
+package gptscript
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CallTree is the hierarchy of the calls of a run. Calls are linked to their parents by ParentID, and by the sub calls
+// in the output of the parent call.
+type CallTree struct {
+	// Roots are the calls that don't have a parent, ordered by their start time.
+	Roots []*CallNode
+	nodes map[string]*CallNode
+}
+
+// CallNode is a call in a CallTree.
+type CallNode struct {
+	Call   CallFrame
+	Parent *CallNode
+	// Children are the calls made by this call, ordered by their start time.
+	Children []*CallNode
+}
+
+// CallTree returns the hierarchy of the calls of the run so far.
+func (r *Run) CallTree() *CallTree {
+	return NewCallTree(r.Calls())
+}
+
+// NewCallTree builds the hierarchy of the given calls. Calls with a parent that isn't in calls are roots.
+func NewCallTree(calls CallFrames) *CallTree {
+	t := &CallTree{nodes: make(map[string]*CallNode, len(calls))}
+	for id, call := range calls {
+		t.nodes[id] = &CallNode{Call: call}
+	}
+
+	// The sub calls in the output of a call are only needed for calls that don't have a ParentID.
+	subCallParents := make(map[string]string)
+	for id, call := range calls {
+		for _, output := range call.Output {
+			for subCallID := range output.SubCalls {
+				subCallParents[subCallID] = id
+			}
+		}
+	}
+
+	for id, node := range t.nodes {
+		parentID := node.Call.ParentID
+		if parentID == "" {
+			parentID = subCallParents[id]
+		}
+
+		parent, ok := t.nodes[parentID]
+		if !ok || parent == node || parent.hasAncestor(node) {
+			// Links that would create a cycle are ignored, so that the tree can always be walked.
+			t.Roots = append(t.Roots, node)
+			continue
+		}
+
+		node.Parent = parent
+		parent.Children = append(parent.Children, node)
+	}
+
+	sortCallNodes(t.Roots)
+	for _, node := range t.nodes {
+		sortCallNodes(node.Children)
+	}
+
+	return t
+}
+
+func (n *CallNode) hasAncestor(ancestor *CallNode) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+func sortCallNodes(nodes []*CallNode) {
+	slices.SortFunc(nodes, func(a, b *CallNode) int {
+		if c := a.Call.Start.Compare(b.Call.Start); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Call.ID, b.Call.ID)
+	})
+}
+
+// Node returns the node of the call with the given ID.
+func (t *CallTree) Node(id string) (*CallNode, bool) {
+	n, ok := t.nodes[id]
+	return n, ok
+}
+
+// Walk calls fn for each call in the tree, depth first, with the depth of the call starting at zero for the roots.
+// The children of a call are skipped if fn returns false.
+func (t *CallTree) Walk(fn func(node *CallNode, depth int) bool) {
+	for _, root := range t.Roots {
+		root.walk(fn, 0)
+	}
+}
+
+// Walk calls fn for this call and each call below it, depth first, with the depth relative to this call.
+// The children of a call are skipped if fn returns false.
+func (n *CallNode) Walk(fn func(node *CallNode, depth int) bool) {
+	n.walk(fn, 0)
+}
+
+func (n *CallNode) walk(fn func(node *CallNode, depth int) bool, depth int) {
+	if !fn(n, depth) {
+		return
+	}
+	for _, child := range n.Children {
+		child.walk(fn, depth+1)
+	}
+}
+
+// Path returns the calls from a root to the call with the given ID, or nil if the call is not in the tree.
+func (t *CallTree) Path(id string) []*CallNode {
+	n, ok := t.nodes[id]
+	if !ok {
+		return nil
+	}
+
+	var path []*CallNode
+	for ; n != nil; n = n.Parent {
+		path = append(path, n)
+	}
+	slices.Reverse(path)
+	return path
+}
+
+// Duration returns the time from the start of the earliest call to the end of the latest call in this subtree.
+// Calls that haven't started or finished are ignored.
+func (n *CallNode) Duration() time.Duration {
+	var start, end time.Time
+	n.Walk(func(node *CallNode, _ int) bool {
+		if s := node.Call.Start; !s.IsZero() && (start.IsZero() || s.Before(start)) {
+			start = s
+		}
+		if e := node.Call.End; e.After(end) {
+			end = e
+		}
+		return true
+	})
+
+	if start.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// Usage returns the total usage of the calls in this subtree.
+func (n *CallNode) Usage() Usage {
+	var u Usage
+	n.Walk(func(node *CallNode, _ int) bool {
+		u.PromptTokens += node.Call.Usage.PromptTokens
+		u.CompletionTokens += node.Call.Usage.CompletionTokens
+		u.TotalTokens += node.Call.Usage.TotalTokens
+		return true
+	})
+	return u
+}
+
+// Name returns the name of the tool of the call.
+func (n *CallNode) Name() string {
+	return firstSet(n.Call.ToolName, n.Call.Tool.Name, n.Call.DisplayText, n.Call.ID)
+}
+
+// String renders the tree as indented text, with one line per call, for debugging.
+func (t *CallTree) String() string {
+	var sb strings.Builder
+	t.Walk(func(node *CallNode, depth int) bool {
+		sb.WriteString(strings.Repeat("  ", depth))
+		sb.WriteString(node.String())
+		sb.WriteString("\n")
+		return true
+	})
+	return sb.String()
+}
+
+// String describes the call on a single line.
+func (n *CallNode) String() string {
+	s := fmt.Sprintf("%s (%s)", n.Name(), n.Call.ID)
+	if n.Call.ToolCategory != NoCategory {
+		s += fmt.Sprintf(" [%s]", n.Call.ToolCategory)
+	}
+	if d := n.Duration(); d > 0 {
+		s += fmt.Sprintf(" %s", d)
+	}
+	if u := n.Usage(); u.TotalTokens > 0 {
+		s += fmt.Sprintf(" %d tokens", u.TotalTokens)
+	}
+	return s
+}
@@ -0,0 +1,80 @@
+package gptscript
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallTree(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	call := func(id, parentID, name string, startOffset, endOffset time.Duration, tokens int) CallFrame {
+		return CallFrame{
+			CallContext: CallContext{ID: id, ParentID: parentID, ToolName: name},
+			Start:       start.Add(startOffset),
+			End:         start.Add(endOffset),
+			Usage:       Usage{TotalTokens: tokens},
+		}
+	}
+
+	root := call("1", "", "agent", 0, 10*time.Second, 100)
+	// The parent of the second call is only known from the output of the root call.
+	root.Output = []Output{{SubCalls: map[string]Call{"2": {ToolID: "search"}}}}
+	credential := call("4", "1", "credential", time.Second, 2*time.Second, 0)
+	credential.ToolCategory = CredentialToolCategory
+
+	tree := NewCallTree(CallFrames{
+		"1": root,
+		"2": call("2", "", "search", 2*time.Second, 8*time.Second, 50),
+		"3": call("3", "2", "fetch", 3*time.Second, 12*time.Second, 25),
+		"4": credential,
+	})
+
+	require.Len(t, tree.Roots, 1)
+	require.Equal(t, "1", tree.Roots[0].Call.ID)
+
+	var ids []string
+	tree.Walk(func(node *CallNode, _ int) bool {
+		ids = append(ids, node.Call.ID)
+		// Skip the children of the search call.
+		return node.Call.ID != "2"
+	})
+	require.Equal(t, []string{"1", "4", "2"}, ids)
+
+	var pathIDs []string
+	for _, n := range tree.Path("3") {
+		pathIDs = append(pathIDs, n.Call.ID)
+	}
+	require.Equal(t, []string{"1", "2", "3"}, pathIDs)
+	require.Nil(t, tree.Path("missing"))
+
+	search, ok := tree.Node("2")
+	require.True(t, ok)
+	require.Equal(t, 10*time.Second, search.Duration())
+	require.Equal(t, Usage{TotalTokens: 75}, search.Usage())
+	require.Equal(t, 12*time.Second, tree.Roots[0].Duration())
+	require.Equal(t, Usage{TotalTokens: 175}, tree.Roots[0].Usage())
+
+	require.Equal(t, `agent (1) 12s 175 tokens
+  credential (4) [credential] 1s
+  search (2) 10s 75 tokens
+    fetch (3) 9s 25 tokens
+`, tree.String())
+}
+
+func TestCallTreeCycle(t *testing.T) {
+	tree := NewCallTree(CallFrames{
+		"1": {CallContext: CallContext{ID: "1", ParentID: "2"}},
+		"2": {CallContext: CallContext{ID: "2", ParentID: "1"}},
+		"3": {CallContext: CallContext{ID: "3", ParentID: "3"}},
+	})
+
+	var count int
+	tree.Walk(func(*CallNode, int) bool {
+		count++
+		return true
+	})
+	require.Equal(t, 3, count)
+	require.Len(t, tree.Roots, 2)
+}
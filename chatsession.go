@@ -0,0 +1,567 @@
+package gptscript
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrChatSessionNotFound is returned, possibly wrapped, when a chat session isn't in a store.
+var ErrChatSessionNotFound = errors.New("chat session not found")
+
+// ErrChatSessionExists is returned, possibly wrapped, when a new chat session has the ID of a session in the store.
+var ErrChatSessionExists = errors.New("chat session already exists")
+
+var chatSessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ChatSessionRecord is the persisted state of a ChatSession.
+type ChatSessionRecord struct {
+	ID string `json:"id"`
+	// ToolPath is the file that is run for each turn, unless Tools is set.
+	ToolPath string `json:"toolPath,omitempty"`
+	// Tools are the tools that are evaluated for each turn.
+	Tools []ToolDef `json:"tools,omitempty"`
+	// ChatState is the chat state after the last turn that didn't fail.
+	ChatState string `json:"chatState,omitempty"`
+	// Done indicates that the chat ended, so there can't be more turns.
+	Done  bool       `json:"done,omitempty"`
+	Turns []ChatTurn `json:"turns,omitempty"`
+	// ParentID is the ID of the session this one was branched from, and ParentTurns is the number of turns of the
+	// parent session that it started with.
+	ParentID    string    `json:"parentID,omitempty"`
+	ParentTurns int       `json:"parentTurns,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// ChatTurn is an input sent to a chat session and the output of the run for it.
+type ChatTurn struct {
+	Input  string `json:"input"`
+	Output string `json:"output,omitempty"`
+	// Error is the error of the run, if it failed. The chat state is not changed by a turn that failed.
+	Error string `json:"error,omitempty"`
+	RunID string `json:"runID,omitempty"`
+	// Tool is the name of the tool that responded.
+	Tool string `json:"tool,omitempty"`
+	// ChatState and Done are the chat state after the turn and whether the chat ended with it, so that the session can
+	// be rewound to the turn. They are not set for turns that failed.
+	ChatState string    `json:"chatState,omitempty"`
+	Done      bool      `json:"done,omitempty"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+}
+
+// ChatSessionStore persists chat sessions. Implementations must be safe for concurrent use.
+type ChatSessionStore interface {
+	// Load returns the chat session with the ID, or an error that matches ErrChatSessionNotFound if there isn't one.
+	Load(ctx context.Context, id string) (ChatSessionRecord, error)
+	// Save creates or replaces the chat session.
+	Save(ctx context.Context, record ChatSessionRecord) error
+	// Delete removes the chat session. It is not an error if there isn't one.
+	Delete(ctx context.Context, id string) error
+}
+
+// ChatSessionOptions configures a new ChatSession.
+type ChatSessionOptions struct {
+	// ID is the ID of the session. The default is a random ID.
+	ID string
+	// ToolPath is the file that is run for each turn, unless Tools is set.
+	ToolPath string
+	// Tools are the tools that are evaluated for each turn.
+	Tools []ToolDef
+	// Options are the options of the run for each turn. The Input and ChatState are set by the session.
+	Options Options
+}
+
+// ChatSession is a chat that is persisted to a store after each turn, so that it can be resumed by its ID, even by
+// another process. Turns are sent one at a time.
+type ChatSession struct {
+	g     *GPTScript
+	store ChatSessionStore
+	opts  Options
+
+	// sendLock is held while a turn is sent, so that turns are sent one at a time, and lock protects the state of the
+	// session, so that it can be read while a turn is sent.
+	sendLock sync.Mutex
+	lock     sync.Mutex
+	record   ChatSessionRecord
+	lastRun  *Run
+}
+
+// NewChatSession starts a chat session and saves it to the store. It returns an error that matches ErrChatSessionExists
+// if the store already has a session with the ID, which is continued with ResumeChatSession instead.
+func (g *GPTScript) NewChatSession(ctx context.Context, store ChatSessionStore, opts ChatSessionOptions) (*ChatSession, error) {
+	if opts.ToolPath == "" && len(opts.Tools) == 0 {
+		return nil, errors.New("chat session must have a tool path or tools")
+	}
+
+	if opts.ID == "" {
+		opts.ID = newChatSessionID()
+	} else if err := validateChatSessionID(opts.ID); err != nil {
+		return nil, err
+	} else if err := checkNewChatSessionID(ctx, store, opts.ID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s := &ChatSession{
+		g:     g,
+		store: store,
+		opts:  opts.Options,
+		record: ChatSessionRecord{
+			ID:        opts.ID,
+			ToolPath:  opts.ToolPath,
+			Tools:     opts.Tools,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+	}
+
+	if err := store.Save(ctx, s.record); err != nil {
+		return nil, fmt.Errorf("failed to save chat session: %w", err)
+	}
+
+	return s, nil
+}
+
+// ResumeChatSession loads a chat session from the store, so that it continues where it was left. The options are used
+// for the run of each turn, as with ChatSessionOptions.
+func (g *GPTScript) ResumeChatSession(ctx context.Context, store ChatSessionStore, id string, opts Options) (*ChatSession, error) {
+	record, err := store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChatSession{
+		g:      g,
+		store:  store,
+		opts:   opts,
+		record: record,
+	}, nil
+}
+
+// ID returns the ID of the session, which is used to resume it.
+func (s *ChatSession) ID() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.record.ID
+}
+
+// ChatState returns the chat state after the last turn that didn't fail.
+func (s *ChatSession) ChatState() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.record.ChatState
+}
+
+// Done returns whether the chat ended, so there can't be more turns.
+func (s *ChatSession) Done() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.record.Done
+}
+
+// Turns returns the turns of the session so far, in order.
+func (s *ChatSession) Turns() []ChatTurn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return slices.Clone(s.record.Turns)
+}
+
+// LastRun returns the run of the last turn sent with this ChatSession, or nil if there wasn't one. It is nil for a
+// session that was just resumed.
+func (s *ChatSession) LastRun() *Run {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lastRun
+}
+
+// Send sends the input as the next turn of the chat, waits for the output, and saves the session. Use the Options of the
+// session, like OnEvent, to receive the events of the run; the events channel of the run is discarded, even if
+// IncludeEvents is set. If the run fails, then the turn is saved with the error and the chat state isn't changed, so the
+// input can be sent again.
+func (s *ChatSession) Send(ctx context.Context, input string) (string, error) {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	s.lock.Lock()
+	record := s.record
+	s.lock.Unlock()
+
+	if record.Done {
+		return "", errors.New("chat session is done")
+	}
+
+	opts := s.opts
+	opts.Input = input
+	opts.ChatState = record.ChatState
+
+	var (
+		turn = ChatTurn{Input: input, Start: time.Now()}
+		run  *Run
+		out  string
+		err  error
+	)
+	if len(record.Tools) > 0 {
+		run, err = s.g.Evaluate(ctx, opts, record.Tools...)
+	} else {
+		run, err = s.g.Run(ctx, record.ToolPath, opts)
+	}
+	if err == nil {
+		// Nothing else can receive from the events channel of the run, so the events are discarded rather than stopping
+		// the run once their buffer is full.
+		go func() {
+			for range run.Events() {
+			}
+		}()
+		out, err = run.Text()
+	}
+	turn.End = time.Now()
+
+	if run != nil {
+		run.callsLock.RLock()
+		turn.RunID = run.id
+		run.callsLock.RUnlock()
+		turn.Tool = run.RespondingTool().Name
+	}
+	if err != nil {
+		turn.Error = err.Error()
+	} else {
+		turn.Output = out
+		turn.ChatState = run.ChatState()
+		turn.Done = run.State() == Finished
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if run != nil {
+		s.lastRun = run
+	}
+	if err == nil {
+		s.record.ChatState, s.record.Done = turn.ChatState, turn.Done
+	}
+
+	s.record.Turns = append(s.record.Turns, turn)
+	s.record.UpdatedAt = turn.End
+	if saveErr := s.store.Save(ctx, s.record); saveErr != nil {
+		return out, errors.Join(err, fmt.Errorf("failed to save chat session: %w", saveErr))
+	}
+
+	return out, err
+}
+
+// Rewind discards the turns of the session after the first n, and saves it. The chat continues from the chat state of
+// the last of those turns that didn't fail, so the next turn can replace the input of a discarded turn. If a turn is
+// being sent, then Rewind waits for it to be saved first.
+func (s *ChatSession) Rewind(ctx context.Context, n int) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if n < 0 || n > len(s.record.Turns) {
+		return fmt.Errorf("cannot rewind to turn %d: the session has %d turns", n, len(s.record.Turns))
+	}
+
+	record := s.record
+	record.Turns = slices.Clone(record.Turns[:n])
+	record.ChatState, record.Done = chatStateAfter(record.Turns)
+	record.UpdatedAt = time.Now()
+
+	if err := s.store.Save(ctx, record); err != nil {
+		return fmt.Errorf("failed to save chat session: %w", err)
+	}
+
+	s.record = record
+	s.lastRun = nil
+	return nil
+}
+
+// Branch starts a new session, in the same store, with the first n turns of this session, so that the chat can continue
+// differently from that turn without changing this session. The new session has the ID, or a random ID if it is empty,
+// and uses the same options as this session. As with NewChatSession, the ID can't be that of a session in the store.
+func (s *ChatSession) Branch(ctx context.Context, n int, id string) (*ChatSession, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if n < 0 || n > len(s.record.Turns) {
+		return nil, fmt.Errorf("cannot branch from turn %d: the session has %d turns", n, len(s.record.Turns))
+	}
+
+	if id == "" {
+		id = newChatSessionID()
+	} else if err := validateChatSessionID(id); err != nil {
+		return nil, err
+	} else if err := checkNewChatSessionID(ctx, s.store, id); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	record := ChatSessionRecord{
+		ID:          id,
+		ToolPath:    s.record.ToolPath,
+		Tools:       s.record.Tools,
+		Turns:       slices.Clone(s.record.Turns[:n]),
+		ParentID:    s.record.ID,
+		ParentTurns: n,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	record.ChatState, record.Done = chatStateAfter(record.Turns)
+
+	if err := s.store.Save(ctx, record); err != nil {
+		return nil, fmt.Errorf("failed to save chat session: %w", err)
+	}
+
+	return &ChatSession{
+		g:      s.g,
+		store:  s.store,
+		opts:   s.opts,
+		record: record,
+	}, nil
+}
+
+// chatStateAfter returns the chat state after the last of the turns that didn't fail, and whether the chat ended.
+func chatStateAfter(turns []ChatTurn) (string, bool) {
+	for i := len(turns) - 1; i >= 0; i-- {
+		if turns[i].Error == "" {
+			return turns[i].ChatState, turns[i].Done
+		}
+	}
+	return "", false
+}
+
+// Delete removes the session from its store.
+func (s *ChatSession) Delete(ctx context.Context) error {
+	return s.store.Delete(ctx, s.ID())
+}
+
+func newChatSessionID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// checkNewChatSessionID returns an error if the store has a session with the ID, so that a new session doesn't overwrite
+// it.
+func checkNewChatSessionID(ctx context.Context, store ChatSessionStore, id string) error {
+	_, err := store.Load(ctx, id)
+	switch {
+	case err == nil:
+		return fmt.Errorf("%w: %s", ErrChatSessionExists, id)
+	case errors.Is(err, ErrChatSessionNotFound):
+		return nil
+	default:
+		return fmt.Errorf("failed to load chat session: %w", err)
+	}
+}
+
+// validateChatSessionID checks that the ID can be used as a file name by the stores.
+func validateChatSessionID(id string) error {
+	if !chatSessionIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid chat session ID %q: must only have letters, digits, '.', '_', and '-'", id)
+	}
+	return nil
+}
+
+// MemoryChatSessionStore keeps chat sessions in memory. It is useful for tests and for sessions that don't need to
+// outlive the process.
+type MemoryChatSessionStore struct {
+	lock     sync.Mutex
+	sessions map[string][]byte
+}
+
+// NewMemoryChatSessionStore returns an empty MemoryChatSessionStore.
+func NewMemoryChatSessionStore() *MemoryChatSessionStore {
+	return &MemoryChatSessionStore{sessions: make(map[string][]byte)}
+}
+
+func (m *MemoryChatSessionStore) Load(_ context.Context, id string) (ChatSessionRecord, error) {
+	m.lock.Lock()
+	b, ok := m.sessions[id]
+	m.lock.Unlock()
+
+	if !ok {
+		return ChatSessionRecord{}, fmt.Errorf("%w: %s", ErrChatSessionNotFound, id)
+	}
+	return decodeChatSession(b)
+}
+
+func (m *MemoryChatSessionStore) Save(_ context.Context, record ChatSessionRecord) error {
+	// The record is stored encoded, so that it isn't changed through slices shared with the session.
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.sessions[record.ID] = b
+	return nil
+}
+
+func (m *MemoryChatSessionStore) Delete(_ context.Context, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// FileChatSessionStore keeps each chat session in a JSON file named after its ID in a directory.
+type FileChatSessionStore struct {
+	Dir string
+}
+
+// NewFileChatSessionStore returns a store for the directory, which is created when the first session is saved.
+func NewFileChatSessionStore(dir string) *FileChatSessionStore {
+	return &FileChatSessionStore{Dir: dir}
+}
+
+func (f *FileChatSessionStore) path(id string) (string, error) {
+	if err := validateChatSessionID(id); err != nil {
+		return "", err
+	}
+	return filepath.Join(f.Dir, id+".json"), nil
+}
+
+func (f *FileChatSessionStore) Load(_ context.Context, id string) (ChatSessionRecord, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return ChatSessionRecord{}, err
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ChatSessionRecord{}, fmt.Errorf("%w: %s", ErrChatSessionNotFound, id)
+	} else if err != nil {
+		return ChatSessionRecord{}, fmt.Errorf("failed to read chat session: %w", err)
+	}
+
+	return decodeChatSession(b)
+}
+
+func (f *FileChatSessionStore) Save(_ context.Context, record ChatSessionRecord) error {
+	path, err := f.path(record.ID)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(f.Dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create chat session directory: %w", err)
+	}
+
+	// The file is replaced by renaming, so that a session that is being saved can still be loaded.
+	tmp, err := os.CreateTemp(f.Dir, "."+record.ID+"-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write chat session: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err == nil {
+		err = tmp.Close()
+	} else {
+		_ = tmp.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write chat session: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *FileChatSessionStore) Delete(_ context.Context, id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete chat session: %w", err)
+	}
+	return nil
+}
+
+// WorkspaceChatSessionStore keeps each chat session in a JSON file in a workspace, under the "chat-sessions" directory.
+type WorkspaceChatSessionStore struct {
+	g           *GPTScript
+	workspaceID string
+}
+
+// NewWorkspaceChatSessionStore returns a store for the workspace. If the workspace ID is empty, then the workspace is
+// the one in the GPTSCRIPT_WORKSPACE_ID environment variable, as with the other workspace functions.
+func NewWorkspaceChatSessionStore(g *GPTScript, workspaceID string) *WorkspaceChatSessionStore {
+	return &WorkspaceChatSessionStore{g: g, workspaceID: workspaceID}
+}
+
+func (w *WorkspaceChatSessionStore) path(id string) (string, error) {
+	if err := validateChatSessionID(id); err != nil {
+		return "", err
+	}
+	return "chat-sessions/" + id + ".json", nil
+}
+
+func (w *WorkspaceChatSessionStore) Load(ctx context.Context, id string) (ChatSessionRecord, error) {
+	path, err := w.path(id)
+	if err != nil {
+		return ChatSessionRecord{}, err
+	}
+
+	b, err := w.g.ReadFileInWorkspace(ctx, path, ReadFileInWorkspaceOptions{WorkspaceID: w.workspaceID})
+	if errors.Is(err, ErrWorkspaceFileNotFound) {
+		return ChatSessionRecord{}, fmt.Errorf("%w: %s", ErrChatSessionNotFound, id)
+	} else if err != nil {
+		return ChatSessionRecord{}, fmt.Errorf("failed to read chat session: %w", err)
+	}
+
+	return decodeChatSession(b)
+}
+
+func (w *WorkspaceChatSessionStore) Save(ctx context.Context, record ChatSessionRecord) error {
+	path, err := w.path(record.ID)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return w.g.WriteFileInWorkspace(ctx, path, b, WriteFileInWorkspaceOptions{WorkspaceID: w.workspaceID})
+}
+
+func (w *WorkspaceChatSessionStore) Delete(ctx context.Context, id string) error {
+	path, err := w.path(id)
+	if err != nil {
+		return err
+	}
+
+	if err := w.g.DeleteFileInWorkspace(ctx, path, DeleteFileInWorkspaceOptions{WorkspaceID: w.workspaceID}); err != nil && !errors.Is(err, ErrWorkspaceFileNotFound) {
+		return err
+	}
+	return nil
+}
+
+func decodeChatSession(b []byte) (ChatSessionRecord, error) {
+	var record ChatSessionRecord
+	if err := json.Unmarshal(b, &record); err != nil {
+		return ChatSessionRecord{}, fmt.Errorf("failed to decode chat session: %w", err)
+	}
+	return record, nil
+}
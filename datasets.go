@@ -46,10 +46,10 @@ type getDatasetElementArgs struct {
 }
 
 func (g *GPTScript) ListDatasets(ctx context.Context) ([]DatasetMeta, error) {
-	out, err := g.runBasicCommand(ctx, "datasets", datasetRequest{
+	out, err := g.runRetryableCommand(ctx, "datasets", datasetRequest{
 		Input:       "{}",
 		DatasetTool: g.globalOpts.DatasetTool,
-		Env:         g.globalOpts.Env,
+		Env:         g.options().Env,
 	})
 	if err != nil {
 		return nil, err
@@ -93,7 +93,7 @@ func (g *GPTScript) AddDatasetElements(ctx context.Context, datasetID string, el
 	return g.runBasicCommand(ctx, "datasets/add-elements", datasetRequest{
 		Input:       string(argsJSON),
 		DatasetTool: g.globalOpts.DatasetTool,
-		Env:         g.globalOpts.Env,
+		Env:         g.options().Env,
 	})
 }
 
@@ -106,10 +106,10 @@ func (g *GPTScript) ListDatasetElements(ctx context.Context, datasetID string) (
 		return nil, fmt.Errorf("failed to marshal element args: %w", err)
 	}
 
-	out, err := g.runBasicCommand(ctx, "datasets/list-elements", datasetRequest{
+	out, err := g.runRetryableCommand(ctx, "datasets/list-elements", datasetRequest{
 		Input:       string(argsJSON),
 		DatasetTool: g.globalOpts.DatasetTool,
-		Env:         g.globalOpts.Env,
+		Env:         g.options().Env,
 	})
 	if err != nil {
 		return nil, err
@@ -132,10 +132,10 @@ func (g *GPTScript) GetDatasetElement(ctx context.Context, datasetID, elementNam
 		return DatasetElement{}, fmt.Errorf("failed to marshal element args: %w", err)
 	}
 
-	out, err := g.runBasicCommand(ctx, "datasets/get-element", datasetRequest{
+	out, err := g.runRetryableCommand(ctx, "datasets/get-element", datasetRequest{
 		Input:       string(argsJSON),
 		DatasetTool: g.globalOpts.DatasetTool,
-		Env:         g.globalOpts.Env,
+		Env:         g.options().Env,
 	})
 	if err != nil {
 		return DatasetElement{}, err
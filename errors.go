@@ -0,0 +1,128 @@
+package gptscript
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var errAbortRun = errors.New("run aborted")
+
+// RunError holds the details common to all the errors returned by runs and other calls to the SDK server.
+// Every error type in this file can be matched to a RunError with errors.As.
+type RunError struct {
+	// StatusCode is the status code of the response from the SDK server, or zero if there was no response.
+	StatusCode int
+	// Output is the error output of the run.
+	Output string
+	// RunID is the ID of the run, if it started.
+	RunID string
+	// CallID is the ID of the call that failed, if known.
+	CallID string
+	// Details is the structured error returned by the SDK server, if any.
+	Details json.RawMessage
+	// Err is the underlying error.
+	Err error
+}
+
+func (e RunError) Error() string {
+	return fmt.Sprintf("run encountered an error: %v with error output: %s", e.Err, e.Output)
+}
+
+func (e RunError) Unwrap() error {
+	return e.Err
+}
+
+// ErrNotFound is returned when the SDK server responds with 404 Not Found.
+type ErrNotFound struct {
+	Message string
+	RunError
+}
+
+func (e ErrNotFound) Error() string {
+	return e.Message
+}
+
+func (e ErrNotFound) Unwrap() error {
+	return e.RunError
+}
+
+// ErrUnauthorized is returned when the SDK server responds with 401 Unauthorized or 403 Forbidden.
+type ErrUnauthorized struct {
+	RunError
+}
+
+func (e ErrUnauthorized) Unwrap() error {
+	return e.RunError
+}
+
+// ErrBadRequest is returned when the SDK server rejects the request as invalid.
+type ErrBadRequest struct {
+	RunError
+}
+
+func (e ErrBadRequest) Unwrap() error {
+	return e.RunError
+}
+
+// ErrToolExecution is returned when a run fails because a command tool, other than a model provider, failed. The failed
+// call is found from the run's events, so a run that fails without them, or while several calls are running, returns a
+// RunError instead.
+type ErrToolExecution struct {
+	RunError
+}
+
+func (e ErrToolExecution) Unwrap() error {
+	return e.RunError
+}
+
+// ErrModelProvider is returned when a run fails because the call to a model provider failed, or because a call's request
+// to the model was sent and never answered. Like ErrToolExecution, it is only returned if the run includes events.
+type ErrModelProvider struct {
+	RunError
+}
+
+func (e ErrModelProvider) Unwrap() error {
+	return e.RunError
+}
+
+// ErrAborted is returned when a run is stopped with Close.
+type ErrAborted struct {
+	RunError
+}
+
+func (e ErrAborted) Unwrap() error {
+	return e.RunError
+}
+
+// ErrBudgetExceeded is returned when a run is aborted because it exceeded a limit of Options.Budget.
+type ErrBudgetExceeded struct {
+	// Limit is the limit that was exceeded.
+	Limit BudgetLimit
+	// PartialOutput is the output of the run when it was aborted.
+	PartialOutput string
+	RunError
+}
+
+func (e ErrBudgetExceeded) Unwrap() error {
+	return e.RunError
+}
+
+// ErrServerUnavailable is returned when the SDK server cannot be reached, the connection to it is lost, or a proxy in
+// front of it reports that it is unavailable.
+type ErrServerUnavailable struct {
+	RunError
+}
+
+func (e ErrServerUnavailable) Unwrap() error {
+	return e.RunError
+}
+
+// runFinishError is the error reported by the SDK server when a run finishes.
+type runFinishError struct {
+	message string
+}
+
+func (e runFinishError) Error() string {
+	return e.message
+}
@@ -0,0 +1,225 @@
+package gptscript
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultEventBufferSize is the size of the buffer of a run's events channel, unless Options.EventBufferSize is set.
+const defaultEventBufferSize = 100
+
+// EventDelivery is the policy for delivering the events of a run on Run.Events when they aren't received as fast as
+// they arrive.
+type EventDelivery string
+
+const (
+	// EventDeliveryBlock stops reading the events of the run while the buffer of the events channel is full. This is
+	// the default. Output, like Run.Text, isn't available until all the events are received from the channel.
+	EventDeliveryBlock EventDelivery = "block"
+	// EventDeliveryDropOldest drops the oldest event in the buffer of the events channel when it is full, so reading
+	// the run never stops. Run.DroppedEvents returns the number of dropped events.
+	EventDeliveryDropOldest EventDelivery = "dropOldest"
+	// EventDeliveryUnbounded buffers all the events that haven't been received from the events channel, so reading the
+	// run never stops and no events are dropped. The events channel must still be drained: until all the events are
+	// received, even after the run has finished, they are kept in memory by a goroutine that delivers them.
+	EventDeliveryUnbounded EventDelivery = "unbounded"
+)
+
+// eventQueue delivers the events of a run according to the options of the run.
+type eventQueue struct {
+	ctx     context.Context
+	policy  EventDelivery
+	onEvent func(Frame)
+	// channel indicates that events are sent on the events channel.
+	channel bool
+	out     chan Frame
+	dropped atomic.Int64
+
+	// lock protects the subscriptions and the events that are waiting to be delivered with the unbounded policy.
+	lock    sync.Mutex
+	subs    []*Subscription
+	pending []Frame
+	closed  bool
+	notify  chan struct{}
+}
+
+// newEventQueue creates a queue for the events of a run. Sending events stops blocking when the context is done.
+func newEventQueue(ctx context.Context, opts Options) *eventQueue {
+	size := opts.EventBufferSize
+	if size <= 0 {
+		size = defaultEventBufferSize
+	}
+
+	q := &eventQueue{
+		ctx:     ctx,
+		policy:  opts.EventDelivery,
+		onEvent: opts.OnEvent,
+		channel: opts.IncludeEvents && opts.OnEvent == nil,
+		out:     make(chan Frame, size),
+	}
+
+	for _, sub := range opts.Subscriptions {
+		q.subscribe(sub)
+	}
+
+	if q.channel && q.policy == EventDeliveryUnbounded {
+		q.notify = make(chan struct{}, 1)
+		go q.deliver()
+	}
+
+	return q
+}
+
+// send delivers the event to the subscriptions, and then to Options.OnEvent if it is set, or to the events channel if
+// the run includes events.
+func (q *eventQueue) send(event Frame) {
+	q.publish(event)
+	if q.onEvent != nil {
+		q.onEvent(event)
+	}
+	if !q.channel {
+		return
+	}
+
+	switch q.policy {
+	case EventDeliveryDropOldest:
+		for {
+			select {
+			case q.out <- event:
+				return
+			default:
+			}
+
+			// The buffer is full, so make room by dropping the oldest event, unless the consumer just did.
+			select {
+			case <-q.out:
+				q.dropped.Add(1)
+			default:
+			}
+		}
+	case EventDeliveryUnbounded:
+		q.lock.Lock()
+		q.pending = append(q.pending, event)
+		q.lock.Unlock()
+		q.signal()
+	default:
+		select {
+		case q.out <- event:
+		case <-q.ctx.Done():
+			// The run was closed, so nothing is going to receive the event.
+		}
+	}
+}
+
+// close closes the events channel once all the events sent to the queue are delivered.
+func (q *eventQueue) close() {
+	if q.notify == nil {
+		close(q.out)
+		return
+	}
+
+	q.lock.Lock()
+	q.closed = true
+	q.lock.Unlock()
+	q.signal()
+}
+
+func (q *eventQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// deliver moves the pending events to the events channel, for the unbounded policy.
+func (q *eventQueue) deliver() {
+	defer close(q.out)
+
+	for {
+		q.lock.Lock()
+		if len(q.pending) == 0 {
+			closed := q.closed
+			q.lock.Unlock()
+			if closed {
+				return
+			}
+
+			<-q.notify
+			continue
+		}
+
+		event := q.pending[0]
+		q.pending = q.pending[1:]
+		q.lock.Unlock()
+
+		q.out <- event
+	}
+}
+
+// EventFilter selects the events that are delivered to a subscription. The zero value selects all events.
+type EventFilter struct {
+	// Types are the types of the events to select. If it is empty, then events of all types are selected.
+	Types []EventType
+	// ToolCategories are the tool categories of the call events to select. If it is empty, then call events of all
+	// categories are selected. Run and prompt events are not affected.
+	ToolCategories []ToolCategory
+	// ExcludeToolCategories are the tool categories of the call events to skip. Run and prompt events are not affected.
+	ExcludeToolCategories []ToolCategory
+}
+
+// Matches returns whether the filter selects the event.
+func (f EventFilter) Matches(event Frame) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, event.Type()) {
+		return false
+	}
+
+	if event.Call == nil {
+		return true
+	}
+
+	if len(f.ToolCategories) > 0 && !slices.Contains(f.ToolCategories, event.Call.ToolCategory) {
+		return false
+	}
+	return !slices.Contains(f.ExcludeToolCategories, event.Call.ToolCategory)
+}
+
+// Subscription is a handler for the events of a run that match the filter.
+type Subscription struct {
+	Filter EventFilter
+	// Handler is called with each matching event. It is called from the goroutine that reads the run, so it should not
+	// block, and it must not wait for the run to finish, like with Run.Text or Run.Close. Use Run.Stop to stop the run.
+	Handler func(Frame)
+}
+
+// subscribe adds the subscription to the queue, and returns a function that removes it.
+func (q *eventQueue) subscribe(sub Subscription) func() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	s := &sub
+	q.subs = append(q.subs, s)
+
+	return func() {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		// Copy the subscriptions, because they may be in use by publish.
+		q.subs = slices.DeleteFunc(slices.Clone(q.subs), func(other *Subscription) bool {
+			return other == s
+		})
+	}
+}
+
+// publish delivers the event to the matching subscriptions, in the order they were added.
+func (q *eventQueue) publish(event Frame) {
+	q.lock.Lock()
+	subs := q.subs
+	q.lock.Unlock()
+
+	for _, sub := range subs {
+		if sub.Handler != nil && sub.Filter.Matches(event) {
+			sub.Handler(event)
+		}
+	}
+}
@@ -26,6 +26,10 @@ const (
 	EventTypeCallConfirm  EventType = "callConfirm"
 	EventTypeCallFinish   EventType = "callFinish"
 	EventTypeRunFinish    EventType = "runFinish"
+	// EventTypeRunReconnected is not sent by the SDK server. It is sent on Run.Events when the connection to the server
+	// was lost and the run's event stream was resumed, which only happens with Options.ResumeStreams and servers that
+	// support resuming streams.
+	EventTypeRunReconnected EventType = "runReconnected"
 
 	EventTypePrompt EventType = "prompt"
 )
@@ -36,6 +40,20 @@ type Frame struct {
 	Prompt *PromptFrame `json:"prompt,omitempty"`
 }
 
+// Type returns the type of the event.
+func (f Frame) Type() EventType {
+	switch {
+	case f.Run != nil:
+		return f.Run.Type
+	case f.Call != nil:
+		return f.Call.Type
+	case f.Prompt != nil:
+		return f.Prompt.Type
+	default:
+		return ""
+	}
+}
+
 type RunFrame struct {
 	ID        string    `json:"id"`
 	Program   Program   `json:"program"`
@@ -1,7 +1,6 @@
 package gptscript
 
 import (
-	"bufio"
 	"bytes"
 	"compress/gzip"
 	"context"
@@ -10,25 +9,26 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
-)
+	"time"
 
-var (
-	serverProcess       *exec.Cmd
-	serverProcessCancel context.CancelFunc
-	gptscriptCount      int
-	serverURL           string
-	lock                sync.Mutex
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const relativeToBinaryPath = "<me>"
 
 type GPTScript struct {
+	lock       sync.Mutex
 	globalOpts GlobalOptions
+	manager    *ServerManager
+	logs       *serverLogs
+	transports map[unixSocketTransportKey]*http.Transport
 }
 
 func NewGPTScript(opts ...GlobalOptions) (*GPTScript, error) {
@@ -39,70 +39,26 @@ func NewGPTScript(opts ...GlobalOptions) (*GPTScript, error) {
 
 	opt.Env = append(opt.Env, opt.toEnv()...)
 
-	lock.Lock()
-	defer lock.Unlock()
-	gptscriptCount++
-
-	startSDK := serverProcess == nil && serverURL == "" && opt.URL == ""
-	if serverURL == "" {
-		serverURL = os.Getenv("GPTSCRIPT_URL")
-		startSDK = startSDK && serverURL == ""
-	}
-
-	if startSDK {
-		ctx, cancel := context.WithCancel(context.Background())
-		in, _ := io.Pipe()
-
-		serverProcess = exec.CommandContext(ctx, getCommand(), "sys.sdkserver", "--listen-address", "127.0.0.1:0")
-		serverProcess.Env = opt.Env[:]
-
-		serverProcess.Stdin = in
-		stdErr, err := serverProcess.StderrPipe()
-		if err != nil {
-			cancel()
-			return nil, fmt.Errorf("failed to get stderr pipe: %w", err)
-		}
-
-		serverProcessCancel = func() {
-			cancel()
-			_ = in.Close()
-			_ = serverProcess.Wait()
-		}
-
-		if err = serverProcess.Start(); err != nil {
-			serverProcessCancel()
-			return nil, fmt.Errorf("failed to start server: %w", err)
+	var manager *ServerManager
+	if opt.URL == "" {
+		switch {
+		case opt.IsolatedServer:
+			manager = newServerManager(opt.Env, opt)
+		case opt.ServerManager != nil:
+			manager = opt.ServerManager
+		default:
+			manager = defaultServerManager
 		}
 
-		serverURL, err = readAddress(stdErr)
+		url, err := manager.acquire(opt)
 		if err != nil {
-			serverProcessCancel()
-			return nil, fmt.Errorf("failed to read server URL: %w", err)
+			return nil, err
 		}
 
-		go func() {
-			for {
-				// Ensure that stdErr is drained as logs come in
-				_, _, _ = bufio.NewReader(stdErr).ReadLine()
-			}
-		}()
-
-		if _, url, found := strings.Cut(serverURL, "addr="); found {
-			// Ensure backwards compatibility with older versions of the SDK server
-			serverURL = url
-		}
-
-		serverURL = strings.TrimSpace(serverURL)
-	}
-
-	if opt.URL == "" {
-		opt.URL = serverURL
-	}
-
-	if !strings.HasPrefix(opt.URL, "http://") && !strings.HasPrefix(opt.URL, "https://") {
-		opt.URL = "http://" + opt.URL
+		opt.URL = url
 	}
 
+	opt.URL = ensureScheme(opt.URL)
 	opt.Env = append(opt.Env, "GPTSCRIPT_URL="+opt.URL)
 
 	if opt.Token == "" {
@@ -112,45 +68,100 @@ func NewGPTScript(opts ...GlobalOptions) (*GPTScript, error) {
 		opt.Env = append(opt.Env, "GPTSCRIPT_TOKEN="+opt.Token)
 	}
 
-	return &GPTScript{
+	g := &GPTScript{
 		globalOpts: opt,
-	}, nil
+		manager:    manager,
+	}
+	if manager != nil {
+		g.logs = &manager.logs
+	}
+
+	return g, nil
 }
 
-func readAddress(stdErr io.Reader) (string, error) {
-	addr, err := bufio.NewReader(stdErr).ReadString('\n')
-	if err != nil {
-		return "", fmt.Errorf("failed to read server address: %w", err)
+func ensureScheme(url string) string {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "unix://") {
+		return "http://" + url
+	}
+	return url
+}
+
+// options returns the global options of this GPTScript instance.
+// If the SDK server is managed and was restarted on a different address, then the options are updated to use it.
+func (g *GPTScript) options() GlobalOptions {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.manager == nil {
+		return g.globalOpts
+	}
+
+	url := g.manager.URL()
+	if url == "" {
+		return g.globalOpts
 	}
 
-	if _, url, found := strings.Cut(addr, "addr="); found {
-		// For backward compatibility: older versions of the SDK server print the address in a slightly different way.
-		addr = url
+	if url = ensureScheme(url); url != g.globalOpts.URL {
+		env := slices.Clone(g.globalOpts.Env)
+		if i := slices.Index(env, "GPTSCRIPT_URL="+g.globalOpts.URL); i >= 0 {
+			env[i] = "GPTSCRIPT_URL=" + url
+		}
+
+		g.globalOpts.URL = url
+		g.globalOpts.Env = env
 	}
 
-	return addr, nil
+	return g.globalOpts
 }
 
 func (g *GPTScript) URL() string {
-	return g.globalOpts.URL
+	return g.options().URL
+}
+
+// ServerLogs returns the most recent lines written to stderr by the SDK server used by this GPTScript instance.
+// These are useful to attach to bug reports when a run fails. Note that the server, and therefore its logs, may be
+// shared with other GPTScript instances. It returns nil if the instance was created with a URL.
+func (g *GPTScript) ServerLogs() []ServerLogEntry {
+	if g.logs == nil {
+		return nil
+	}
+	return g.logs.list()
+}
+
+// ServerManager returns the manager of the SDK server used by this GPTScript instance.
+// It returns nil if the instance was created with a URL.
+func (g *GPTScript) ServerManager() *ServerManager {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.manager
 }
 
+// Close releases the SDK server used by this GPTScript instance, and closes the idle connections to it.
+// The server is stopped once every GPTScript instance sharing it has been closed.
 func (g *GPTScript) Close() {
-	lock.Lock()
-	defer lock.Unlock()
-	gptscriptCount--
+	g.lock.Lock()
+	manager, transports := g.manager, g.transports
+	g.manager, g.transports = nil, nil
+	g.lock.Unlock()
+
+	for _, t := range transports {
+		t.CloseIdleConnections()
+	}
 
-	if gptscriptCount == 0 && serverProcessCancel != nil {
-		serverProcessCancel()
-		_ = serverProcess.Wait()
+	if manager != nil {
+		manager.release()
 	}
 }
 
 func (g *GPTScript) Evaluate(ctx context.Context, opts Options, tools ...ToolDef) (*Run, error) {
-	opts.GlobalOptions = completeGlobalOptions(g.globalOpts, opts.GlobalOptions)
+	opts.GlobalOptions = completeGlobalOptions(g.options(), opts.GlobalOptions)
 	return (&Run{
 		url:         opts.URL,
 		token:       opts.Token,
+		client:      g.httpClient(opts.GlobalOptions),
+		tracer:      opts.tracer(),
+		propagator:  opts.propagator(),
+		metrics:     opts.metrics(),
 		requestPath: "evaluate",
 		state:       Creating,
 		opts:        opts,
@@ -159,10 +170,14 @@ func (g *GPTScript) Evaluate(ctx context.Context, opts Options, tools ...ToolDef
 }
 
 func (g *GPTScript) Run(ctx context.Context, toolPath string, opts Options) (*Run, error) {
-	opts.GlobalOptions = completeGlobalOptions(g.globalOpts, opts.GlobalOptions)
+	opts.GlobalOptions = completeGlobalOptions(g.options(), opts.GlobalOptions)
 	return (&Run{
 		url:         opts.URL,
 		token:       opts.Token,
+		client:      g.httpClient(opts.GlobalOptions),
+		tracer:      opts.tracer(),
+		propagator:  opts.propagator(),
+		metrics:     opts.metrics(),
 		requestPath: "run",
 		state:       Creating,
 		opts:        opts,
@@ -186,7 +201,7 @@ func (g *GPTScript) Parse(ctx context.Context, fileName string, opts ...ParseOpt
 		disableCache = disableCache || opt.DisableCache
 	}
 
-	out, err := g.runBasicCommand(ctx, "parse", map[string]any{"file": fileName, "disableCache": disableCache})
+	out, err := g.runRetryableCommand(ctx, "parse", map[string]any{"file": fileName, "disableCache": disableCache})
 	if err != nil {
 		return nil, err
 	}
@@ -205,7 +220,7 @@ func (g *GPTScript) Parse(ctx context.Context, fileName string, opts ...ParseOpt
 
 // ParseContent will parse the given string into a tool.
 func (g *GPTScript) ParseContent(ctx context.Context, toolDef string) ([]Node, error) {
-	out, err := g.runBasicCommand(ctx, "parse", map[string]any{"content": toolDef})
+	out, err := g.runRetryableCommand(ctx, "parse", map[string]any{"content": toolDef})
 	if err != nil {
 		return nil, err
 	}
@@ -228,7 +243,7 @@ func (g *GPTScript) Fmt(ctx context.Context, nodes []Node) (string, error) {
 		node.TextNode.combine()
 	}
 
-	out, err := g.runBasicCommand(ctx, "fmt", Document{Nodes: nodes})
+	out, err := g.runRetryableCommand(ctx, "fmt", Document{Nodes: nodes})
 	if err != nil {
 		return "", err
 	}
@@ -266,7 +281,7 @@ func (g *GPTScript) load(ctx context.Context, payload map[string]any, opts ...Lo
 		}
 	}
 
-	out, err := g.runBasicCommand(ctx, "load", payload)
+	out, err := g.runRetryableCommand(ctx, "load", payload)
 	if err != nil {
 		return nil, err
 	}
@@ -285,7 +300,7 @@ func (g *GPTScript) load(ctx context.Context, payload map[string]any, opts ...Lo
 
 // Version will return the output of `gptscript --version`
 func (g *GPTScript) Version(ctx context.Context) (string, error) {
-	out, err := g.runBasicCommand(ctx, "version", nil)
+	out, err := g.runRetryableCommand(ctx, "version", nil)
 	if err != nil {
 		return "", err
 	}
@@ -336,9 +351,9 @@ func (g *GPTScript) ListModels(ctx context.Context, opts ...ListModelsOptions) (
 		o.Providers = append(o.Providers, g.globalOpts.DefaultModelProvider)
 	}
 
-	out, err := g.runBasicCommand(ctx, "list-models", map[string]any{
+	out, err := g.runRetryableCommand(ctx, "list-models", map[string]any{
 		"providers":           o.Providers,
-		"env":                 g.globalOpts.Env,
+		"env":                 g.options().Env,
 		"credentialOverrides": o.CredentialOverrides,
 	})
 	if err != nil {
@@ -378,7 +393,7 @@ func (g *GPTScript) ListCredentials(ctx context.Context, opts ListCredentialsOpt
 		req.Context = []string{"default"}
 	}
 
-	out, err := g.runBasicCommand(ctx, "credentials", req)
+	out, err := g.runRetryableCommand(ctx, "credentials", req)
 	if err != nil {
 		return nil, err
 	}
@@ -406,7 +421,7 @@ func (g *GPTScript) RecreateAllCredentials(ctx context.Context) error {
 }
 
 func (g *GPTScript) RevealCredential(ctx context.Context, credCtxs []string, name string) (Credential, error) {
-	out, err := g.runBasicCommand(ctx, "credentials/reveal", CredentialRequest{
+	out, err := g.runRetryableCommand(ctx, "credentials/reveal", CredentialRequest{
 		Context: credCtxs,
 		Name:    name,
 	})
@@ -430,26 +445,56 @@ func (g *GPTScript) DeleteCredential(ctx context.Context, credCtx, name string)
 }
 
 func (g *GPTScript) runBasicCommand(ctx context.Context, requestPath string, body any) (string, error) {
+	out, _, err := g.runBasicCommandWithStatus(ctx, requestPath, body)
+	return out, err
+}
+
+// runBasicCommandWithStatus runs the command and also returns the status code of the response,
+// which is zero if no response was received.
+func (g *GPTScript) runBasicCommandWithStatus(ctx context.Context, requestPath string, body any) (string, int, error) {
+	opts := g.options()
+	return sendCommand(ctx, opts, g.httpClient(opts), "", requestPath, body)
+}
+
+// sendCommand sends a basic command to the URL of the options with the client, and the token if it isn't empty, and
+// records the command with the tracer and metrics of the options.
+func sendCommand(ctx context.Context, opts GlobalOptions, client *http.Client, token, requestPath string, body any) (out string, statusCode int, err error) {
 	run := &Run{
-		url:          g.globalOpts.URL,
+		url:          opts.URL,
+		client:       client,
+		propagator:   opts.propagator(),
 		requestPath:  requestPath,
 		state:        Creating,
 		basicCommand: true,
-	}
+		opts:         Options{GlobalOptions: GlobalOptions{Token: token}},
+	}
+
+	start := time.Now()
+	ctx, span := opts.tracer().Start(ctx, "gptscript."+commandName(requestPath),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("gptscript.request", requestPath)),
+	)
+	defer func() {
+		opts.metrics().CommandFinished(commandName(requestPath), time.Since(start), err)
+		if statusCode != 0 {
+			span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+		}
+		endSpan(span, err)
+	}()
 
 	if err := run.request(ctx, body); err != nil {
-		return "", err
+		return "", run.responseCode, err
 	}
 
-	out, err := run.Text()
+	out, err = run.Text()
 	if err != nil {
-		return "", err
+		return "", run.responseCode, err
 	}
 	if run.err != nil {
-		return run.ErrorOutput(), run.err
+		return run.ErrorOutput(), run.responseCode, run.err
 	}
 
-	return out, nil
+	return out, run.responseCode, nil
 }
 
 func getCommand() string {
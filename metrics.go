@@ -0,0 +1,59 @@
+package gptscript
+
+import (
+	"time"
+)
+
+// Metrics records the metrics of the runs and commands of a GPTScript instance. Implementations must be safe for
+// concurrent use, and should return quickly because they are called while events are being processed.
+// The metrics package has an implementation that exposes them in the Prometheus text format.
+type Metrics interface {
+	// RunStarted is called when a run, or the next run of a chat, is started. The request is "run" or "evaluate".
+	RunStarted(request string)
+	// RunFinished is called when a run ends, with its state, which is Finished, Continue, or Error, and its duration.
+	RunFinished(request string, state RunState, duration time.Duration)
+	// CallFinished is called when a call of a run finishes, with the name of its tool and the duration of the call.
+	CallFinished(tool string, duration time.Duration)
+	// TokensUsed is called with the usage of a call when it finishes, by the model the call used. As in UsageReport,
+	// the model of tools without a model name is the DefaultModel of the run, or DefaultModelKey if it isn't set. It
+	// isn't called for calls with a cached response, which didn't consume any tokens.
+	TokensUsed(model string, usage Usage)
+	// EventsDropped is called when a run ends with the number of its events that were dropped because of the
+	// EventDeliveryDropOldest policy, if any were.
+	EventsDropped(count int)
+	// CommandFinished is called when a basic command, like parse, load, or a workspace operation, finishes.
+	// The command is the request path without IDs, like "workspaces/read-file" or "confirm".
+	CommandFinished(command string, duration time.Duration, err error)
+}
+
+// noMetrics is used when there is no Metrics.
+type noMetrics struct{}
+
+func (noMetrics) RunStarted(string)                            {}
+func (noMetrics) RunFinished(string, RunState, time.Duration)  {}
+func (noMetrics) CallFinished(string, time.Duration)           {}
+func (noMetrics) TokensUsed(string, Usage)                     {}
+func (noMetrics) EventsDropped(int)                            {}
+func (noMetrics) CommandFinished(string, time.Duration, error) {}
+
+// metrics returns the Metrics of the options, which is never nil.
+func (g GlobalOptions) metrics() Metrics {
+	if g.Metrics != nil {
+		return g.Metrics
+	}
+	return noMetrics{}
+}
+
+// recordCall records the metrics of a call that finished. The tokens of tools without a model name are recorded under
+// the default model.
+func recordCall(m Metrics, call CallFrame, defaultModel string) {
+	var duration time.Duration
+	if !call.Start.IsZero() && call.End.After(call.Start) {
+		duration = call.End.Sub(call.Start)
+	}
+	m.CallFinished(firstSet(call.ToolName, call.Tool.Name), duration)
+
+	if !call.ChatResponseCached && call.Usage != (Usage{}) {
+		m.TokensUsed(firstSet(call.Tool.ModelName, defaultModel, DefaultModelKey), call.Usage)
+	}
+}
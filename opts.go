@@ -1,5 +1,18 @@
 package gptscript
 
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
 // GlobalOptions allows specification of settings that are used for every call made.
 // These options can be overridden by the corresponding Options.
 type GlobalOptions struct {
@@ -13,6 +26,38 @@ type GlobalOptions struct {
 	Env                  []string `json:"env"`
 	DatasetTool          string   `json:"DatasetTool"`
 	WorkspaceTool        string   `json:"WorkspaceTool"`
+
+	// ServerManager is the manager of the SDK server used by NewGPTScript when URL is not set.
+	// By default, a server is shared by all GPTScript instances in the process.
+	ServerManager *ServerManager `json:"-"`
+	// IsolatedServer causes NewGPTScript to start an SDK server used only by the new GPTScript instance,
+	// with the instance's environment. It takes precedence over ServerManager.
+	IsolatedServer bool `json:"-"`
+	// ServerLogger receives the logs of the SDK server started by NewGPTScript.
+	// Lines that are JSON or logfmt are parsed into a level, message, and attributes.
+	ServerLogger *slog.Logger `json:"-"`
+	// ServerSocket is the path of a Unix domain socket for the SDK server started by NewGPTScript to listen on,
+	// instead of a localhost TCP port. The server is started with "--listen-address unix://<path>", so the gptscript
+	// binary must support listening on Unix domain sockets.
+	ServerSocket string `json:"-"`
+	// HTTPClient is the client used for every request to the SDK server. The default is http.DefaultClient.
+	HTTPClient *http.Client `json:"-"`
+	// HTTPTransport is the transport used for every request to the SDK server.
+	// If HTTPClient is also set, then this replaces its transport.
+	HTTPTransport http.RoundTripper `json:"-"`
+	// RetryPolicy configures the retries of idempotent requests to the SDK server. Requests are not retried by default.
+	RetryPolicy *RetryPolicy `json:"-"`
+	// TracerProvider creates the OpenTelemetry spans of runs, their calls, and commands. The default is the global
+	// provider, which doesn't record spans unless one is registered with otel.SetTracerProvider. Setting it also includes
+	// events in runs, which the spans of the calls are made from.
+	TracerProvider trace.TracerProvider `json:"-"`
+	// Propagator adds the trace context to the requests to the SDK server, so that the server's spans are part of the
+	// same trace. The default is the global propagator from otel.GetTextMapPropagator.
+	Propagator propagation.TextMapPropagator `json:"-"`
+	// Metrics records the metrics of runs and commands, like the number of runs, the durations of calls, and the tokens
+	// used by each model. Metrics aren't recorded by default. Setting it also includes events in runs, which the metrics
+	// of calls and tokens are taken from.
+	Metrics Metrics `json:"-"`
 }
 
 func (g GlobalOptions) toEnv() []string {
@@ -36,6 +81,70 @@ func (g GlobalOptions) toEnv() []string {
 	return args
 }
 
+// httpClient returns the client to use for requests to the SDK server with the given options.
+// If the URL is a unix:// URL, then the client's transport dials the socket, unless it is a custom http.RoundTripper.
+func (g *GPTScript) httpClient(opts GlobalOptions) *http.Client {
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	transport := opts.HTTPTransport
+	if socket, ok := strings.CutPrefix(opts.URL, "unix://"); ok {
+		base := firstSet(transport, client.Transport, http.DefaultTransport)
+		if t, ok := base.(*http.Transport); ok {
+			transport = g.unixSocketTransport(t, socket)
+		}
+	}
+
+	if transport != nil {
+		c := *client
+		c.Transport = transport
+		client = &c
+	}
+
+	return client
+}
+
+type unixSocketTransportKey struct {
+	base   *http.Transport
+	socket string
+}
+
+// unixSocketTransport returns a clone of the given transport that dials the socket for every request. The clone is kept
+// by the GPTScript instance, so that connections are reused across requests until the instance is closed.
+func (g *GPTScript) unixSocketTransport(base *http.Transport, socket string) *http.Transport {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	key := unixSocketTransportKey{base: base, socket: socket}
+	if t, ok := g.transports[key]; ok {
+		return t
+	}
+
+	t := base.Clone()
+	t.Proxy = nil
+	t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socket)
+	}
+
+	if g.transports == nil {
+		g.transports = make(map[unixSocketTransportKey]*http.Transport)
+	}
+	g.transports[key] = t
+	return t
+}
+
+// requestURL returns the URL for the request path on the SDK server at the given URL.
+// For unix:// URLs, the host of the returned URL is a placeholder because the transport dials the socket.
+func requestURL(url, requestPath string) string {
+	if strings.HasPrefix(url, "unix://") {
+		return "http://unix/" + requestPath
+	}
+	return fmt.Sprintf("%s/%s", url, requestPath)
+}
+
 func completeGlobalOptions(opts ...GlobalOptions) GlobalOptions {
 	var result GlobalOptions
 	for _, opt := range opts {
@@ -49,6 +158,24 @@ func completeGlobalOptions(opts ...GlobalOptions) GlobalOptions {
 		result.DatasetTool = firstSet(opt.DatasetTool, result.DatasetTool)
 		result.WorkspaceTool = firstSet(opt.WorkspaceTool, result.WorkspaceTool)
 		result.Env = append(result.Env, opt.Env...)
+		result.ServerManager = firstSet(opt.ServerManager, result.ServerManager)
+		result.IsolatedServer = firstSet(opt.IsolatedServer, result.IsolatedServer)
+		result.ServerLogger = firstSet(opt.ServerLogger, result.ServerLogger)
+		result.ServerSocket = firstSet(opt.ServerSocket, result.ServerSocket)
+		result.HTTPClient = firstSet(opt.HTTPClient, result.HTTPClient)
+		result.RetryPolicy = firstSet(opt.RetryPolicy, result.RetryPolicy)
+		if opt.HTTPTransport != nil {
+			result.HTTPTransport = opt.HTTPTransport
+		}
+		if opt.TracerProvider != nil {
+			result.TracerProvider = opt.TracerProvider
+		}
+		if opt.Propagator != nil {
+			result.Propagator = opt.Propagator
+		}
+		if opt.Metrics != nil {
+			result.Metrics = opt.Metrics
+		}
 	}
 	return result
 }
@@ -80,4 +207,31 @@ type Options struct {
 	CredentialContexts  []string `json:"credentialContexts"`
 	Location            string   `json:"location"`
 	ForceSequential     bool     `json:"forceSequential"`
+
+	// EventDelivery is the policy for delivering events on Run.Events when they aren't received as fast as they
+	// arrive. The default is EventDeliveryBlock.
+	EventDelivery EventDelivery `json:"-"`
+	// EventBufferSize is the size of the buffer of the events channel. The default is 100.
+	EventBufferSize int `json:"-"`
+	// ResumeStreams resumes the run's event stream from the last event that was received, if the connection to the SDK
+	// server is lost. The server must send the ID of each event and serve the "resume/{runID}" endpoint, which the
+	// gptscript SDK server doesn't do yet, so it is off by default and losing the connection fails the run.
+	ResumeStreams bool `json:"-"`
+	// OnEvent is called with each event of the run, instead of sending the events on Run.Events. It is called from the
+	// goroutine that reads the run, so it should not block, and it must not wait for the run to finish, like with
+	// Run.Text or Run.Close. Use Run.Stop to stop the run. Setting it also includes events in the run.
+	OnEvent func(Frame) `json:"-"`
+	// Subscriptions receive the events of the run that match their filters, in addition to OnEvent or Run.Events.
+	// Setting them also includes events in the run.
+	Subscriptions []Subscription `json:"-"`
+	// StreamText asks the server for the events of the run, so that Run.TextStream streams the text as it is
+	// generated, without sending the events on Run.Events.
+	StreamText bool `json:"-"`
+	// Budget limits the tokens, tool calls, and time the run can use. The run is aborted when a limit is exceeded.
+	Budget Budget `json:"-"`
+	// NewRecording returns the writer that receives a recording of the run's events and output, as a JSON object per
+	// line, which Replay can replay without an SDK server. It is called for each run, including the runs created by
+	// Run.NextChat and the turns of a ChatSession, because a recording has a single run. A run isn't recorded if it
+	// returns nil.
+	NewRecording func() io.Writer `json:"-"`
 }
@@ -0,0 +1,425 @@
+package gptscripttest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gptscript-ai/go-gptscript"
+)
+
+// AbortedOutput is the output of a scripted run that is aborted by the client.
+const AbortedOutput = "ABORTED BY USER"
+
+var (
+	// ErrAborted is the cause of a run's context when the run is aborted by the client.
+	ErrAborted = errors.New("run aborted")
+
+	errServerClosed = errors.New("server closed")
+)
+
+// RunRequest is the decoded body of a "run" or "evaluate" request.
+type RunRequest struct {
+	gptscript.Options `json:",inline"`
+
+	// Path is either "run" or "evaluate".
+	Path     string              `json:"-"`
+	File     string              `json:"file"`
+	Input    string              `json:"input"`
+	ToolDefs []gptscript.ToolDef `json:"toolDefs"`
+}
+
+// RunFunc handles a "run" or "evaluate" request by writing to the stream.
+type RunFunc func(req RunRequest, stream *Stream)
+
+// ChatOutput is the stdout of a chat run. Use it as Script.Output or with Stream.Stdout.
+type ChatOutput struct {
+	Content string `json:"content"`
+	Done    bool   `json:"done"`
+	State   any    `json:"state"`
+	ToolID  string `json:"toolID,omitempty"`
+}
+
+// Script is a canned response to a "run" or "evaluate" request.
+type Script struct {
+	// StatusCode fails the request with Stderr as the error output, if it is 400 or above.
+	StatusCode int
+	// Events are streamed to the client, in order, waiting Delay before each one. Like the real server, the events are
+	// only sent if the request has IncludeEvents set, but Delay is still waited for.
+	Events []gptscript.Frame
+	Delay  time.Duration
+	// Output is sent as stdout after all the events. It is usually a string or a ChatOutput.
+	Output any
+	Stderr string
+}
+
+func (sc Script) run(_ RunRequest, stream *Stream) {
+	if sc.StatusCode >= http.StatusBadRequest {
+		stream.Fail(sc.StatusCode, sc.Stderr)
+		return
+	}
+
+	for _, event := range sc.Events {
+		if sc.Delay > 0 {
+			select {
+			case <-time.After(sc.Delay):
+			case <-stream.Context().Done():
+			}
+		}
+
+		if stream.Context().Err() != nil {
+			break
+		}
+
+		if err := stream.Send(event); err != nil {
+			return
+		}
+	}
+
+	if errors.Is(context.Cause(stream.Context()), ErrAborted) {
+		_ = stream.Stdout(AbortedOutput)
+		return
+	} else if stream.Context().Err() != nil {
+		return
+	}
+
+	if sc.Stderr != "" {
+		if err := stream.Stderr(sc.Stderr); err != nil {
+			return
+		}
+	}
+	if sc.Output != nil {
+		_ = stream.Stdout(sc.Output)
+	}
+}
+
+// HandleRun registers the function that handles "run" and "evaluate" requests when no scripts are queued.
+func (s *Server) HandleRun(fn RunFunc) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.runFunc = fn
+}
+
+// Script queues canned responses for "run" and "evaluate" requests. Each request consumes the next script.
+func (s *Server) Script(scripts ...Script) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.scripts = append(s.scripts, scripts...)
+}
+
+func (s *Server) nextRunFunc() RunFunc {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.scripts) > 0 {
+		sc := s.scripts[0]
+		s.scripts = s.scripts[1:]
+		return sc.run
+	}
+
+	return s.runFunc
+}
+
+func (s *Server) serveRun(w http.ResponseWriter, r *http.Request, req Request) {
+	runReq := RunRequest{Path: req.Path}
+	if len(req.Body) > 0 {
+		if err := req.Decode(&runReq); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode run request: %v", err))
+			return
+		}
+	}
+
+	fn := s.nextRunFunc()
+	if fn == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no run scripted for %s", req.Path))
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+
+	stream := &Stream{
+		server:        s,
+		resumable:     s.resumeEnabled(),
+		w:             w,
+		ctx:           ctx,
+		cancel:        cancel,
+		includeEvents: runReq.IncludeEvents,
+		ended:         make(chan struct{}),
+	}
+	defer stream.finish()
+
+	fn(runReq, stream)
+}
+
+// Stream writes the server-sent events of a run to the client. If the server has resuming enabled, then events are sent
+// with IDs, so that the client can resume the stream from the last event it received after losing its connection.
+type Stream struct {
+	server *Server
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	runIDs []string
+	// includeEvents is whether the client asked for the events of the run.
+	includeEvents bool
+	// resumable is whether the stream can be resumed, because the server had resuming enabled when the run started.
+	resumable bool
+
+	// lock protects the connection to the client, which changes when the client resumes the stream, and the state below.
+	lock sync.Mutex
+	w    http.ResponseWriter
+	// events are the events sent so far, which are replayed to clients that resume the stream. They are only kept if the
+	// stream is resumable.
+	events [][]byte
+	// sent is the number of events sent so far.
+	sent int
+	// ended is closed when the run ends.
+	ended chan struct{}
+
+	started, failed, completed bool
+}
+
+// Context returns the context of the run. It is canceled when the client disconnects or aborts the run.
+// In the latter case, the cause of the context is ErrAborted.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Fail responds to the request with an error status code. It must be called before anything is sent on the stream.
+func (s *Stream) Fail(statusCode int, message string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.started || s.failed || s.completed || s.w == nil {
+		return
+	}
+
+	s.failed = true
+	writeError(s.w, statusCode, message)
+}
+
+// Send sends a frame to the client. Sending a run start frame allows the client to abort the run by its ID.
+// The frame is only sent if the request has IncludeEvents set, as the real server does, but the run is still started.
+func (s *Stream) Send(frame gptscript.Frame) error {
+	if frame.Run != nil && frame.Run.Type == gptscript.EventTypeRunStart && frame.Run.ID != "" {
+		s.server.lock.Lock()
+		s.server.runs[frame.Run.ID] = s.cancel
+		if s.resumable {
+			s.server.streams[frame.Run.ID] = s
+		}
+		s.server.lock.Unlock()
+		s.runIDs = append(s.runIDs, frame.Run.ID)
+	}
+
+	if !s.includeEvents {
+		return nil
+	}
+	return s.write(frame)
+}
+
+// Stdout sends the output of the run to the client.
+func (s *Stream) Stdout(out any) error {
+	return s.write(map[string]any{"stdout": out})
+}
+
+// Stderr sends error output of the run to the client.
+func (s *Stream) Stderr(message string) error {
+	return s.write(map[string]any{"stderr": message})
+}
+
+// WaitForConfirm blocks until the client confirms the call with the given ID or the run is canceled.
+func (s *Stream) WaitForConfirm(id string) (gptscript.AuthResponse, error) {
+	select {
+	case resp := <-s.server.confirmChan(id):
+		return resp, nil
+	case <-s.ctx.Done():
+		return gptscript.AuthResponse{}, context.Cause(s.ctx)
+	}
+}
+
+// WaitForPromptResponse blocks until the client responds to the prompt with the given ID or the run is canceled.
+func (s *Stream) WaitForPromptResponse(id string) (map[string]string, error) {
+	select {
+	case resp := <-s.server.responseChan(id):
+		return resp, nil
+	case <-s.ctx.Done():
+		return nil, context.Cause(s.ctx)
+	}
+}
+
+// Disconnect drops the connection to the client without ending the run, as if the network failed. If the stream is
+// resumable, then events sent after it are kept, so that the client receives them when it resumes the stream.
+// Otherwise, they are dropped, like the real server does.
+func (s *Stream) Disconnect() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.w == nil {
+		return nil
+	}
+
+	conn, _, err := http.NewResponseController(s.w).Hijack()
+	if err != nil {
+		return fmt.Errorf("failed to hijack connection: %w", err)
+	}
+
+	s.w = nil
+	return conn.Close()
+}
+
+func (s *Stream) write(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.failed {
+		return fmt.Errorf("stream has already failed")
+	}
+
+	s.sent++
+	if s.resumable {
+		s.events = append(s.events, b)
+	}
+	if s.w == nil {
+		// The client is disconnected, and will receive the event if it resumes the stream.
+		return nil
+	}
+
+	if !s.started {
+		s.started = true
+		startEventStream(s.w, s.resumable)
+	}
+
+	return writeEvent(s.w, s.eventID(s.sent), b)
+}
+
+// eventID returns the ID of the nth event of the stream, which is empty unless the stream is resumable.
+func (s *Stream) eventID(n int) string {
+	if !s.resumable {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// attach sends the events after the given ID to a client that is resuming the stream, and then makes it the client that
+// receives new events. It returns a channel that is closed when the run ends.
+func (s *Stream) attach(w http.ResponseWriter, lastID int) <-chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	startEventStream(w, true)
+	for i := max(lastID, 0); i < len(s.events); i++ {
+		if err := writeEvent(w, s.eventID(i+1), s.events[i]); err != nil {
+			return s.ended
+		}
+	}
+
+	if s.completed {
+		s.forget()
+		writeDone(w)
+	} else {
+		s.w = w
+	}
+
+	return s.ended
+}
+
+// detach stops sending events to a client that disconnected after resuming the stream.
+func (s *Stream) detach(w http.ResponseWriter) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.w == w {
+		s.w = nil
+	}
+}
+
+func (s *Stream) finish() {
+	defer close(s.ended)
+
+	s.server.lock.Lock()
+	for _, id := range s.runIDs {
+		delete(s.server.runs, id)
+	}
+	s.server.lock.Unlock()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.failed || s.ctx.Err() != nil && !errors.Is(context.Cause(s.ctx), ErrAborted) {
+		s.forget()
+		return
+	}
+
+	s.completed = true
+	if s.w == nil {
+		if s.resumable {
+			// The client is disconnected, so the stream is kept until the client resumes it and receives the end.
+			return
+		}
+		s.forget()
+		return
+	}
+
+	if !s.started {
+		// Nothing was sent, but the client still expects a successful stream.
+		s.started = true
+		startEventStream(s.w, s.resumable)
+	}
+
+	s.forget()
+	writeDone(s.w)
+}
+
+// forget removes the stream from the server once no client can resume it.
+func (s *Stream) forget() {
+	s.server.lock.Lock()
+	defer s.server.lock.Unlock()
+
+	for _, id := range s.runIDs {
+		delete(s.server.streams, id)
+	}
+}
+
+func startEventStream(w http.ResponseWriter, resumable bool) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if resumable {
+		// Ask clients to resume the stream quickly after losing their connection.
+		_, _ = fmt.Fprint(w, "retry: 10\n\n")
+	}
+}
+
+// writeEvent writes an event with the data and, if it isn't empty, the ID.
+func writeEvent(w http.ResponseWriter, id string, data []byte) error {
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+func writeDone(w http.ResponseWriter) {
+	_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
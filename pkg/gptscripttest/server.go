@@ -0,0 +1,354 @@
+// Package gptscripttest provides an in-process fake of the GPTScript SDK server.
+//
+// The fake speaks the same HTTP protocol as `gptscript sys.sdkserver`, so code built on
+// gptscript.GPTScript and gptscript.Run can be tested by pointing GlobalOptions.URL at Server.URL.
+package gptscripttest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gptscript-ai/go-gptscript"
+)
+
+// HandlerFunc produces the output for a basic command, such as "parse" or "workspaces/read-file".
+// The returned value is sent as the command's stdout. Returning an *Error controls the status code of the response,
+// any other error is reported with a 500 status code.
+type HandlerFunc func(req Request) (any, error)
+
+// Error is an error response from the fake server.
+type Error struct {
+	StatusCode int
+	Message    string
+	// Details is sent as the structured error of the response, if set, like newer SDK servers do for workspace errors.
+	Details any
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Request is a request that was received by the fake server.
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// Decode will unmarshal the body of the request into v.
+func (r Request) Decode(v any) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// Server is a fake SDK server. Create one with NewServer.
+type Server struct {
+	// URL is the base URL of the server. It should be used as GlobalOptions.URL.
+	URL string
+
+	srv *httptest.Server
+
+	lock      sync.Mutex
+	resume    bool
+	handlers  map[string]HandlerFunc
+	runFunc   RunFunc
+	scripts   []Script
+	requests  []Request
+	runs      map[string]context.CancelCauseFunc
+	streams   map[string]*Stream
+	confirms  map[string]chan gptscript.AuthResponse
+	responses map[string]chan map[string]string
+}
+
+// NewServer starts a fake SDK server. The caller should call Close when finished.
+func NewServer() *Server {
+	s := newServer()
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+	return s
+}
+
+func newServer() *Server {
+	s := &Server{
+		handlers:  make(map[string]HandlerFunc),
+		runs:      make(map[string]context.CancelCauseFunc),
+		streams:   make(map[string]*Stream),
+		confirms:  make(map[string]chan gptscript.AuthResponse),
+		responses: make(map[string]chan map[string]string),
+	}
+
+	s.Respond("version", "gptscript version v0.0.0-gptscripttest")
+	return s
+}
+
+// NewUnixServer starts a fake SDK server listening on a Unix domain socket at the given path.
+// The URL of the server is a unix:// URL. The caller should call Close when finished.
+func NewUnixServer(socket string) (*Server, error) {
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", socket, err)
+	}
+
+	s := newServer()
+	s.srv = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	s.srv.Listener = l
+	s.srv.Start()
+	s.URL = "unix://" + socket
+	return s, nil
+}
+
+// Close shuts down the server and blocks until all outstanding requests have completed.
+func (s *Server) Close() {
+	s.lock.Lock()
+	for id, cancel := range s.runs {
+		cancel(errServerClosed)
+		delete(s.runs, id)
+	}
+	clear(s.streams)
+	s.lock.Unlock()
+
+	s.srv.Close()
+}
+
+// EnableResume makes the server send an ID with each event of a run and serve the "resume/{runID}" endpoint, so that
+// clients with Options.ResumeStreams can resume a stream after losing their connection. The gptscript SDK server doesn't
+// support resuming streams yet, so neither does the fake by default.
+func (s *Server) EnableResume() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.resume = true
+}
+
+func (s *Server) resumeEnabled() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.resume
+}
+
+// Handle registers the handler for the given basic command path, replacing any existing handler.
+func (s *Server) Handle(path string, h HandlerFunc) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.handlers[strings.Trim(path, "/")] = h
+}
+
+// Respond registers a static response for the given basic command path.
+func (s *Server) Respond(path string, out any) {
+	s.Handle(path, func(Request) (any, error) {
+		return out, nil
+	})
+}
+
+// RespondError registers an error response for the given basic command path.
+func (s *Server) RespondError(path string, statusCode int, message string) {
+	s.Handle(path, func(Request) (any, error) {
+		return nil, &Error{StatusCode: statusCode, Message: message}
+	})
+}
+
+// Requests returns all the requests the server has received, in order.
+func (s *Server) Requests() []Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// RequestsFor returns the requests the server has received for the given path, in order.
+func (s *Server) RequestsFor(path string) []Request {
+	path = strings.Trim(path, "/")
+
+	var reqs []Request
+	for _, req := range s.Requests() {
+		if req.Path == path {
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to read request body: %v", err))
+		return
+	}
+
+	req := Request{
+		Method: r.Method,
+		Path:   strings.Trim(r.URL.Path, "/"),
+		Header: r.Header.Clone(),
+		Body:   body,
+	}
+
+	s.lock.Lock()
+	s.requests = append(s.requests, req)
+	s.lock.Unlock()
+
+	switch {
+	case req.Path == "run" || req.Path == "evaluate":
+		s.serveRun(w, r, req)
+	case strings.HasPrefix(req.Path, "resume/") && s.resumeEnabled():
+		s.serveResume(w, r, strings.TrimPrefix(req.Path, "resume/"))
+	case strings.HasPrefix(req.Path, "abort/"):
+		s.serveAbort(w, strings.TrimPrefix(req.Path, "abort/"))
+	case strings.HasPrefix(req.Path, "confirm/"):
+		s.serveConfirm(w, req)
+	case strings.HasPrefix(req.Path, "prompt-response/"):
+		s.servePromptResponse(w, req)
+	default:
+		s.serveBasic(w, req)
+	}
+}
+
+func (s *Server) serveBasic(w http.ResponseWriter, req Request) {
+	s.lock.Lock()
+	h, ok := s.handlers[req.Path]
+	s.lock.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no response registered for %s", req.Path))
+		return
+	}
+
+	out, err := h(req)
+	if err != nil {
+		writeHandlerError(w, err)
+		return
+	}
+
+	writeResponse(w, http.StatusOK, map[string]any{"stdout": out})
+}
+
+func (s *Server) serveAbort(w http.ResponseWriter, id string) {
+	s.lock.Lock()
+	cancel, ok := s.runs[id]
+	s.lock.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("run %s not found", id))
+		return
+	}
+
+	cancel(ErrAborted)
+	writeResponse(w, http.StatusOK, map[string]any{"stdout": "run aborted"})
+}
+
+func (s *Server) serveResume(w http.ResponseWriter, r *http.Request, id string) {
+	s.lock.Lock()
+	stream, ok := s.streams[id]
+	s.lock.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("run %s not found", id))
+		return
+	}
+
+	lastID, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	ended := stream.attach(w, lastID)
+
+	select {
+	case <-ended:
+	case <-r.Context().Done():
+		stream.detach(w)
+	}
+}
+
+func (s *Server) serveConfirm(w http.ResponseWriter, req Request) {
+	var resp gptscript.AuthResponse
+	if err := req.Decode(&resp); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode confirm response: %v", err))
+		return
+	}
+
+	id := strings.TrimPrefix(req.Path, "confirm/")
+	if resp.ID == "" {
+		resp.ID = id
+	}
+
+	// The channel is buffered, so the response is delivered even if nothing is waiting yet.
+	// Duplicate responses for the same ID are dropped.
+	select {
+	case s.confirmChan(id) <- resp:
+	default:
+	}
+	writeResponse(w, http.StatusOK, map[string]any{"stdout": "confirmed"})
+}
+
+func (s *Server) servePromptResponse(w http.ResponseWriter, req Request) {
+	var resp map[string]string
+	if err := req.Decode(&resp); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode prompt response: %v", err))
+		return
+	}
+
+	select {
+	case s.responseChan(strings.TrimPrefix(req.Path, "prompt-response/")) <- resp:
+	default:
+	}
+	writeResponse(w, http.StatusOK, map[string]any{"stdout": "prompt response received"})
+}
+
+func (s *Server) confirmChan(id string) chan gptscript.AuthResponse {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	c, ok := s.confirms[id]
+	if !ok {
+		c = make(chan gptscript.AuthResponse, 1)
+		s.confirms[id] = c
+	}
+	return c
+}
+
+func (s *Server) responseChan(id string) chan map[string]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	c, ok := s.responses[id]
+	if !ok {
+		c = make(chan map[string]string, 1)
+		s.responses[id] = c
+	}
+	return c
+}
+
+func writeHandlerError(w http.ResponseWriter, err error) {
+	if e, ok := err.(*Error); ok {
+		code := e.StatusCode
+		if code == 0 {
+			code = http.StatusInternalServerError
+		}
+		resp := map[string]any{"stderr": e.Message}
+		if e.Details != nil {
+			resp["error"] = e.Details
+		}
+		writeResponse(w, code, resp)
+		return
+	}
+
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeResponse(w, code, map[string]any{"stderr": message})
+}
+
+func writeResponse(w http.ResponseWriter, code int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		code = http.StatusInternalServerError
+		b = []byte(fmt.Sprintf(`{"stderr": %q}`, err.Error()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(b)
+}
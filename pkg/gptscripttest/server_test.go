@@ -0,0 +1,246 @@
+package gptscripttest_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gptscript-ai/go-gptscript"
+	"github.com/gptscript-ai/go-gptscript/pkg/gptscripttest"
+	"github.com/stretchr/testify/require"
+)
+
+func newClient(t *testing.T, s *gptscripttest.Server) *gptscript.GPTScript {
+	t.Helper()
+
+	g, err := gptscript.NewGPTScript(gptscript.GlobalOptions{URL: s.URL})
+	require.NoError(t, err)
+	t.Cleanup(g.Close)
+
+	return g
+}
+
+func TestVersion(t *testing.T) {
+	s := gptscripttest.NewServer()
+	defer s.Close()
+
+	out, err := newClient(t, s).Version(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "gptscript version v0.0.0-gptscripttest", out)
+}
+
+func TestBasicCommand(t *testing.T) {
+	s := gptscripttest.NewServer()
+	defer s.Close()
+
+	s.Respond("parse", gptscript.Document{Nodes: []gptscript.Node{{ToolNode: &gptscript.ToolNode{Tool: gptscript.Tool{ToolDef: gptscript.ToolDef{Name: "foo"}}}}}})
+
+	nodes, err := newClient(t, s).ParseContent(context.Background(), "name: foo")
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	require.Equal(t, "foo", nodes[0].ToolNode.Tool.Name)
+
+	reqs := s.RequestsFor("parse")
+	require.Len(t, reqs, 1)
+
+	var body map[string]any
+	require.NoError(t, reqs[0].Decode(&body))
+	require.Equal(t, "name: foo", body["content"])
+}
+
+func TestBasicCommandError(t *testing.T) {
+	s := gptscripttest.NewServer()
+	defer s.Close()
+
+	s.RespondError("credentials/reveal", http.StatusNotFound, "credential not found")
+
+	_, err := newClient(t, s).RevealCredential(context.Background(), []string{"default"}, "missing")
+	require.ErrorAs(t, err, &gptscript.ErrNotFound{})
+}
+
+func TestScriptedRun(t *testing.T) {
+	s := gptscripttest.NewServer()
+	defer s.Close()
+
+	s.Script(gptscripttest.Script{
+		Events: []gptscript.Frame{
+			{Run: &gptscript.RunFrame{ID: "1", Type: gptscript.EventTypeRunStart}},
+			{Call: &gptscript.CallFrame{CallContext: gptscript.CallContext{ID: "call1"}, Type: gptscript.EventTypeCallFinish, Usage: gptscript.Usage{TotalTokens: 10}}},
+			{Run: &gptscript.RunFrame{ID: "1", Type: gptscript.EventTypeRunFinish}},
+		},
+		Output: "Washington, D.C.",
+	})
+
+	run, err := newClient(t, s).Evaluate(context.Background(), gptscript.Options{IncludeEvents: true, Input: "hello"}, gptscript.ToolDef{Instructions: "What is the capital of the united states?"})
+	require.NoError(t, err)
+
+	var events int
+	for range run.Events() {
+		events++
+	}
+
+	out, err := run.Text()
+	require.NoError(t, err)
+	require.Equal(t, "Washington, D.C.", out)
+	require.Equal(t, 3, events)
+	require.Equal(t, 10, run.Usage().TotalTokens)
+	require.Equal(t, gptscript.Finished, run.State())
+
+	reqs := s.RequestsFor("evaluate")
+	require.Len(t, reqs, 1)
+
+	var req gptscripttest.RunRequest
+	require.NoError(t, reqs[0].Decode(&req))
+	require.Equal(t, "hello", req.Input)
+	require.Len(t, req.ToolDefs, 1)
+
+	// The stream of a finished run isn't kept, so it can't be resumed.
+	resp, err := http.Get(s.URL + "/resume/1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestScriptedRunWithoutEvents(t *testing.T) {
+	s := gptscripttest.NewServer()
+	defer s.Close()
+
+	s.Script(gptscripttest.Script{
+		Events: []gptscript.Frame{
+			{Run: &gptscript.RunFrame{ID: "1", Type: gptscript.EventTypeRunStart}},
+			{Call: &gptscript.CallFrame{CallContext: gptscript.CallContext{ID: "call1"}, Type: gptscript.EventTypeCallFinish, Usage: gptscript.Usage{TotalTokens: 10}}},
+		},
+		Output: "Washington, D.C.",
+	})
+
+	run, err := newClient(t, s).Run(context.Background(), "capital.gpt", gptscript.Options{})
+	require.NoError(t, err)
+
+	out, err := run.Text()
+	require.NoError(t, err)
+	require.Equal(t, "Washington, D.C.", out)
+	// The real server doesn't send events unless they are included, so neither does the fake.
+	require.Empty(t, run.Calls())
+}
+
+func TestScriptedChat(t *testing.T) {
+	s := gptscripttest.NewServer()
+	defer s.Close()
+
+	s.Script(
+		gptscripttest.Script{Output: gptscripttest.ChatOutput{Content: "Hi!", State: "state1"}},
+		gptscripttest.Script{Output: gptscripttest.ChatOutput{Content: "Bye!", State: "state2", Done: true}},
+	)
+
+	run, err := newClient(t, s).Run(context.Background(), "chat.gpt", gptscript.Options{})
+	require.NoError(t, err)
+
+	out, err := run.Text()
+	require.NoError(t, err)
+	require.Equal(t, "Hi!", out)
+	require.Equal(t, gptscript.Continue, run.State())
+	require.Equal(t, `"state1"`, run.ChatState())
+
+	run, err = run.NextChat(context.Background(), "goodbye")
+	require.NoError(t, err)
+
+	out, err = run.Text()
+	require.NoError(t, err)
+	require.Equal(t, "Bye!", out)
+	require.Equal(t, gptscript.Finished, run.State())
+
+	var req gptscripttest.RunRequest
+	require.NoError(t, s.RequestsFor("run")[1].Decode(&req))
+	require.Equal(t, "goodbye", req.Input)
+	require.Equal(t, `"state1"`, req.ChatState)
+}
+
+func TestScriptedRunFailure(t *testing.T) {
+	s := gptscripttest.NewServer()
+	defer s.Close()
+
+	s.Script(gptscripttest.Script{StatusCode: http.StatusBadRequest, Stderr: "bad input"})
+
+	run, err := newClient(t, s).Run(context.Background(), "test.gpt", gptscript.Options{})
+	require.NoError(t, err)
+
+	_, err = run.Text()
+	require.Error(t, err)
+	require.Equal(t, gptscript.Error, run.State())
+	require.Equal(t, "bad input", run.ErrorOutput())
+}
+
+func TestAbortRun(t *testing.T) {
+	s := gptscripttest.NewServer()
+	defer s.Close()
+
+	s.Script(gptscripttest.Script{
+		Events: []gptscript.Frame{
+			{Run: &gptscript.RunFrame{ID: "1", Type: gptscript.EventTypeRunStart}},
+			{Call: &gptscript.CallFrame{CallContext: gptscript.CallContext{ID: "call1"}, Type: gptscript.EventTypeCallProgress}},
+			{Run: &gptscript.RunFrame{ID: "1", Type: gptscript.EventTypeRunFinish}},
+		},
+		Delay:  time.Second,
+		Output: "never sent",
+	})
+
+	g := newClient(t, s)
+	run, err := g.Evaluate(context.Background(), gptscript.Options{IncludeEvents: true}, gptscript.ToolDef{Instructions: "Generate a real long essay about the meaning of life."})
+	require.NoError(t, err)
+
+	<-run.Events()
+	require.NoError(t, g.AbortRun(context.Background(), run))
+
+	for range run.Events() {
+	}
+
+	out, err := run.Text()
+	require.NoError(t, err)
+	require.Equal(t, gptscripttest.AbortedOutput, out)
+}
+
+func TestConfirm(t *testing.T) {
+	s := gptscripttest.NewServer()
+	defer s.Close()
+
+	s.HandleRun(func(_ gptscripttest.RunRequest, stream *gptscripttest.Stream) {
+		_ = stream.Send(gptscript.Frame{Call: &gptscript.CallFrame{CallContext: gptscript.CallContext{ID: "call1"}, Type: gptscript.EventTypeCallConfirm}})
+
+		resp, err := stream.WaitForConfirm("call1")
+		if err != nil {
+			return
+		}
+
+		if resp.Accept {
+			_ = stream.Stdout("accepted")
+		} else {
+			_ = stream.Stdout("denied: " + resp.Message)
+		}
+	})
+
+	g := newClient(t, s)
+	run, err := g.Evaluate(context.Background(), gptscript.Options{IncludeEvents: true, Confirm: true}, gptscript.ToolDef{Instructions: "ls", Tools: []string{"sys.exec"}})
+	require.NoError(t, err)
+
+	for e := range run.Events() {
+		if e.Call != nil && e.Call.Type == gptscript.EventTypeCallConfirm {
+			require.NoError(t, g.Confirm(context.Background(), gptscript.AuthResponse{ID: e.Call.ID, Message: "no"}))
+		}
+	}
+
+	out, err := run.Text()
+	require.NoError(t, err)
+	require.Equal(t, "denied: no", out)
+}
+
+func TestNoRunScripted(t *testing.T) {
+	s := gptscripttest.NewServer()
+	defer s.Close()
+
+	run, err := newClient(t, s).Run(context.Background(), "test.gpt", gptscript.Options{})
+	require.NoError(t, err)
+
+	_, err = run.Text()
+	require.ErrorAs(t, err, &gptscript.ErrNotFound{})
+}
@@ -0,0 +1,328 @@
+// Package metrics collects the metrics of GPTScript runs and commands, and exposes them in the Prometheus text format
+// without depending on a metrics library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gptscript-ai/go-gptscript"
+)
+
+// DefaultBuckets are the default upper bounds, in seconds, of the buckets of the duration histograms.
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// Type is the type of a metric.
+type Type string
+
+const (
+	TypeCounter   Type = "counter"
+	TypeHistogram Type = "histogram"
+)
+
+// Options configures a Collector.
+type Options struct {
+	// Namespace is the prefix of the names of the metrics. The default is "gptscript".
+	Namespace string
+	// Buckets are the upper bounds, in seconds, of the buckets of the duration histograms. The default is DefaultBuckets.
+	Buckets []float64
+}
+
+// Family is a metric and its series, one for each combination of label values that was recorded.
+type Family struct {
+	Name   string
+	Help   string
+	Type   Type
+	Series []Series
+}
+
+// Series is the value of a metric for a combination of label values. Counters only have a Value, and histograms only
+// have Buckets, Sum, and Count.
+type Series struct {
+	Labels map[string]string
+	Value  float64
+
+	Buckets []Bucket
+	Sum     float64
+	Count   uint64
+}
+
+// Bucket is the number of observations of a histogram that are less than or equal to UpperBound.
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// Collector is a gptscript.Metrics that keeps the metrics in memory. Collect returns them, to be exposed with a metrics
+// library, and WriteTo and ServeHTTP write them in the Prometheus text format.
+type Collector struct {
+	buckets []float64
+
+	lock     sync.Mutex
+	families []*family
+
+	runsStarted, runsFinished, runsErrored *family
+	runDuration, callDuration              *family
+	tokens, eventsDropped                  *family
+	commandDuration, commandErrors         *family
+}
+
+var _ gptscript.Metrics = (*Collector)(nil)
+
+// New creates a Collector.
+func New(opts ...Options) *Collector {
+	var opt Options
+	for _, o := range opts {
+		if o.Namespace != "" {
+			opt.Namespace = o.Namespace
+		}
+		if len(o.Buckets) > 0 {
+			opt.Buckets = o.Buckets
+		}
+	}
+	if opt.Namespace == "" {
+		opt.Namespace = "gptscript"
+	}
+	if len(opt.Buckets) == 0 {
+		opt.Buckets = DefaultBuckets
+	}
+
+	c := &Collector{buckets: slices.Sorted(slices.Values(opt.Buckets))}
+	c.runsStarted = c.newFamily(opt.Namespace+"_runs_started_total", "Number of runs started.", TypeCounter, "request")
+	c.runsFinished = c.newFamily(opt.Namespace+"_runs_finished_total", "Number of runs finished, by their state.", TypeCounter, "request", "state")
+	c.runsErrored = c.newFamily(opt.Namespace+"_runs_errored_total", "Number of runs that ended with an error.", TypeCounter, "request")
+	c.runDuration = c.newFamily(opt.Namespace+"_run_duration_seconds", "Duration of runs.", TypeHistogram, "request")
+	c.callDuration = c.newFamily(opt.Namespace+"_call_duration_seconds", "Duration of the calls of runs, by tool.", TypeHistogram, "tool")
+	c.tokens = c.newFamily(opt.Namespace+"_tokens_total", "Number of tokens used, by model and type.", TypeCounter, "model", "type")
+	c.eventsDropped = c.newFamily(opt.Namespace+"_events_dropped_total", "Number of run events dropped because they weren't received in time.", TypeCounter)
+	c.commandDuration = c.newFamily(opt.Namespace+"_command_duration_seconds", "Duration of basic commands, by command.", TypeHistogram, "command")
+	c.commandErrors = c.newFamily(opt.Namespace+"_command_errors_total", "Number of basic commands that failed, by command.", TypeCounter, "command")
+
+	return c
+}
+
+// RunStarted counts the run as started.
+func (c *Collector) RunStarted(request string) {
+	c.add(c.runsStarted, 1, request)
+}
+
+// RunFinished counts the run as finished, and as errored if its state is Error, and records its duration.
+func (c *Collector) RunFinished(request string, state gptscript.RunState, duration time.Duration) {
+	c.add(c.runsFinished, 1, request, string(state))
+	if state == gptscript.Error {
+		c.add(c.runsErrored, 1, request)
+	}
+	c.observe(c.runDuration, duration, request)
+}
+
+// CallFinished records the duration of the call by its tool.
+func (c *Collector) CallFinished(tool string, duration time.Duration) {
+	c.observe(c.callDuration, duration, tool)
+}
+
+// TokensUsed counts the prompt and completion tokens used by the model.
+func (c *Collector) TokensUsed(model string, usage gptscript.Usage) {
+	c.add(c.tokens, float64(usage.PromptTokens), model, "prompt")
+	c.add(c.tokens, float64(usage.CompletionTokens), model, "completion")
+}
+
+// EventsDropped counts the dropped events.
+func (c *Collector) EventsDropped(count int) {
+	c.add(c.eventsDropped, float64(count))
+}
+
+// CommandFinished records the duration of the command, and counts it as an error if it failed.
+func (c *Collector) CommandFinished(command string, duration time.Duration, err error) {
+	c.observe(c.commandDuration, duration, command)
+	if err != nil {
+		c.add(c.commandErrors, 1, command)
+	}
+}
+
+// Collect returns the metrics that were recorded so far. Metrics without any series are not included.
+func (c *Collector) Collect() []Family {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var result []Family
+	for _, f := range c.families {
+		if len(f.series) == 0 {
+			continue
+		}
+
+		family := Family{Name: f.name, Help: f.help, Type: f.typ}
+		for _, key := range slices.Sorted(maps.Keys(f.series)) {
+			s := f.series[key]
+
+			series := Series{Labels: make(map[string]string, len(f.labels)), Value: s.value, Sum: s.sum, Count: s.count}
+			for i, label := range f.labels {
+				series.Labels[label] = s.labelValues[i]
+			}
+
+			if f.typ == TypeHistogram {
+				var cumulative uint64
+				for i, upperBound := range c.buckets {
+					cumulative += s.counts[i]
+					series.Buckets = append(series.Buckets, Bucket{UpperBound: upperBound, Count: cumulative})
+				}
+			}
+
+			family.Series = append(family.Series, series)
+		}
+
+		result = append(result, family)
+	}
+
+	return result
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, family := range c.Collect() {
+		fmt.Fprintf(cw, "# HELP %s %s\n", family.Name, family.Help)
+		fmt.Fprintf(cw, "# TYPE %s %s\n", family.Name, family.Type)
+
+		for _, series := range family.Series {
+			labels := formatLabels(series.Labels)
+			if family.Type == TypeCounter {
+				fmt.Fprintf(cw, "%s%s %s\n", family.Name, labels, formatFloat(series.Value))
+				continue
+			}
+
+			for _, bucket := range series.Buckets {
+				fmt.Fprintf(cw, "%s_bucket%s %d\n", family.Name, formatLabels(series.Labels, "le", formatFloat(bucket.UpperBound)), bucket.Count)
+			}
+			fmt.Fprintf(cw, "%s_bucket%s %d\n", family.Name, formatLabels(series.Labels, "le", "+Inf"), series.Count)
+			fmt.Fprintf(cw, "%s_sum%s %s\n", family.Name, labels, formatFloat(series.Sum))
+			fmt.Fprintf(cw, "%s_count%s %d\n", family.Name, labels, series.Count)
+		}
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format, so that a Collector can be the handler of a metrics
+// endpoint.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = c.WriteTo(w)
+}
+
+type family struct {
+	name, help string
+	typ        Type
+	labels     []string
+	series     map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// counts are the number of observations in each bucket, not including the observations in the previous buckets.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (c *Collector) newFamily(name, help string, typ Type, labels ...string) *family {
+	f := &family{name: name, help: help, typ: typ, labels: labels, series: make(map[string]*series)}
+	c.families = append(c.families, f)
+	return f
+}
+
+// get returns the series of the family for the label values, creating it if needed. The lock must be held.
+func (c *Collector) get(f *family, labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: labelValues}
+		if f.typ == TypeHistogram {
+			s.counts = make([]uint64, len(c.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (c *Collector) add(f *family, value float64, labelValues ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.get(f, labelValues).value += value
+}
+
+func (c *Collector) observe(f *family, duration time.Duration, labelValues ...string) {
+	seconds := duration.Seconds()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s := c.get(f, labelValues)
+	s.sum += seconds
+	s.count++
+	if i, _ := slices.BinarySearch(c.buckets, seconds); i < len(c.buckets) {
+		s.counts[i]++
+	}
+}
+
+func formatLabels(labels map[string]string, extra ...string) string {
+	if len(labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	var pairs []string
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		pairs = append(pairs, name+`="`+escapeLabelValue(labels[name])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabelValue(extra[i+1])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gptscript-ai/go-gptscript"
+	"github.com/gptscript-ai/go-gptscript/pkg/gptscripttest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTo(t *testing.T) {
+	c := New(Options{Namespace: "test", Buckets: []float64{1, 0.1}})
+	c.RunStarted("run")
+	c.RunFinished("run", gptscript.Error, 500*time.Millisecond)
+	c.TokensUsed(`gpt-4o "mini"`, gptscript.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15})
+	c.CommandFinished("parse", 50*time.Millisecond, nil)
+	c.CommandFinished("parse", 2*time.Second, errors.New("failed"))
+
+	var buf bytes.Buffer
+	_, err := c.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, `# HELP test_runs_started_total Number of runs started.
+# TYPE test_runs_started_total counter
+test_runs_started_total{request="run"} 1
+# HELP test_runs_finished_total Number of runs finished, by their state.
+# TYPE test_runs_finished_total counter
+test_runs_finished_total{request="run",state="error"} 1
+# HELP test_runs_errored_total Number of runs that ended with an error.
+# TYPE test_runs_errored_total counter
+test_runs_errored_total{request="run"} 1
+# HELP test_run_duration_seconds Duration of runs.
+# TYPE test_run_duration_seconds histogram
+test_run_duration_seconds_bucket{request="run",le="0.1"} 0
+test_run_duration_seconds_bucket{request="run",le="1"} 1
+test_run_duration_seconds_bucket{request="run",le="+Inf"} 1
+test_run_duration_seconds_sum{request="run"} 0.5
+test_run_duration_seconds_count{request="run"} 1
+# HELP test_tokens_total Number of tokens used, by model and type.
+# TYPE test_tokens_total counter
+test_tokens_total{model="gpt-4o \"mini\"",type="completion"} 5
+test_tokens_total{model="gpt-4o \"mini\"",type="prompt"} 10
+# HELP test_command_duration_seconds Duration of basic commands, by command.
+# TYPE test_command_duration_seconds histogram
+test_command_duration_seconds_bucket{command="parse",le="0.1"} 1
+test_command_duration_seconds_bucket{command="parse",le="1"} 1
+test_command_duration_seconds_bucket{command="parse",le="+Inf"} 2
+test_command_duration_seconds_sum{command="parse"} 2.05
+test_command_duration_seconds_count{command="parse"} 2
+# HELP test_command_errors_total Number of basic commands that failed, by command.
+# TYPE test_command_errors_total counter
+test_command_errors_total{command="parse"} 1
+`, buf.String())
+}
+
+func TestCollect(t *testing.T) {
+	s := gptscripttest.NewServer()
+	defer s.Close()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Script(gptscripttest.Script{
+		Events: []gptscript.Frame{
+			{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}},
+			{Call: &gptscript.CallFrame{
+				CallContext: gptscript.CallContext{ID: "1", ToolName: "agent", Tool: gptscript.Tool{ToolDef: gptscript.ToolDef{ModelName: "gpt-4o"}}},
+				Type:        gptscript.EventTypeCallFinish,
+				Start:       start,
+				End:         start.Add(3 * time.Second),
+				Usage:       gptscript.Usage{PromptTokens: 100, CompletionTokens: 20, TotalTokens: 120},
+			}},
+		},
+		Output: "done",
+	})
+	s.Respond("version", "gptscript version v0.9.5")
+
+	c := New()
+	g, err := gptscript.NewGPTScript(gptscript.GlobalOptions{URL: s.URL, Metrics: c})
+	require.NoError(t, err)
+	defer g.Close()
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{})
+	require.NoError(t, err)
+	_, err = run.Text()
+	require.NoError(t, err)
+
+	_, err = g.Version(context.Background())
+	require.NoError(t, err)
+
+	families := make(map[string]Family)
+	for _, f := range c.Collect() {
+		families[f.Name] = f
+	}
+
+	require.Equal(t, []Series{{Labels: map[string]string{"request": "run", "state": "finished"}, Value: 1}}, families["gptscript_runs_finished_total"].Series)
+	require.NotContains(t, families, "gptscript_runs_errored_total")
+
+	calls := families["gptscript_call_duration_seconds"].Series
+	require.Len(t, calls, 1)
+	require.Equal(t, map[string]string{"tool": "agent"}, calls[0].Labels)
+	require.Equal(t, 3.0, calls[0].Sum)
+	require.Equal(t, uint64(1), calls[0].Count)
+
+	require.Len(t, families["gptscript_tokens_total"].Series, 2)
+	require.Equal(t, 100.0, families["gptscript_tokens_total"].Series[1].Value)
+
+	// The run is recorded as a run, and not as a command.
+	commands := families["gptscript_command_duration_seconds"].Series
+	require.Len(t, commands, 1)
+	require.Equal(t, map[string]string{"command": "version"}, commands[0].Labels)
+}
+
+func TestCollectDefaultModel(t *testing.T) {
+	s := gptscripttest.NewServer()
+	defer s.Close()
+
+	s.Script(gptscripttest.Script{
+		Events: []gptscript.Frame{
+			{Call: &gptscript.CallFrame{
+				CallContext: gptscript.CallContext{ID: "1", ToolName: "agent"},
+				Type:        gptscript.EventTypeCallFinish,
+				Usage:       gptscript.Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5},
+			}},
+		},
+		Output: "done",
+	})
+
+	c := New()
+	g, err := gptscript.NewGPTScript(gptscript.GlobalOptions{URL: s.URL, Metrics: c, DefaultModel: "gpt-4o"})
+	require.NoError(t, err)
+	defer g.Close()
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{})
+	require.NoError(t, err)
+	_, err = run.Text()
+	require.NoError(t, err)
+
+	// The tokens of a tool without a model name are recorded under the default model, as in the run's usage report.
+	var tokens []Series
+	for _, f := range c.Collect() {
+		if f.Name == "gptscript_tokens_total" {
+			tokens = f.Series
+		}
+	}
+	require.Equal(t, []Series{
+		{Labels: map[string]string{"model": "gpt-4o", "type": "completion"}, Value: 2},
+		{Labels: map[string]string{"model": "gpt-4o", "type": "prompt"}, Value: 3},
+	}, tokens)
+	require.Contains(t, run.UsageReport(nil).ByModel, "gpt-4o")
+}
+
+func TestServeHTTP(t *testing.T) {
+	c := New()
+	c.EventsDropped(3)
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	require.Contains(t, rec.Body.String(), "gptscript_events_dropped_total 3\n")
+}
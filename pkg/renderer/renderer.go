@@ -0,0 +1,312 @@
+// Package renderer draws the progress of GPTScript runs in a terminal.
+package renderer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gptscript-ai/go-gptscript"
+)
+
+const (
+	defaultRefreshInterval = 100 * time.Millisecond
+	defaultWidth           = 120
+)
+
+var spinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// Options configures a Renderer.
+type Options struct {
+	// Output is where the run is drawn. The default is os.Stdout.
+	Output io.Writer
+	// Live turns the live view on or off. By default, the live view is used if Output is a terminal, and plain
+	// line-oriented logs are written otherwise.
+	Live *bool
+	// RefreshInterval is the time between redraws of the live view. The default is 100ms.
+	RefreshInterval time.Duration
+	// Width is the maximum width of the lines of the live view. Longer lines are truncated. The default is 120.
+	Width int
+	// Filter selects the calls that are drawn. The default is all calls.
+	Filter gptscript.EventFilter
+}
+
+// Renderer draws the calls of a run as a nested view, with the elapsed time and token usage of each call, followed by
+// the output of the run. Use a Renderer for one run only.
+type Renderer struct {
+	out      io.Writer
+	live     bool
+	interval time.Duration
+	width    int
+	filter   gptscript.EventFilter
+	now      func() time.Time
+
+	lock  sync.Mutex
+	calls gptscript.CallFrames
+	// started and ended are the times the calls were seen starting and finishing, which are used instead of the times
+	// reported by the server so that elapsed times aren't affected by clock skew.
+	started, ended map[string]time.Time
+	// lines is the number of lines of the last drawing of the live view.
+	lines int
+	frame int
+}
+
+// New creates a Renderer.
+func New(opts ...Options) *Renderer {
+	var opt Options
+	for _, o := range opts {
+		if o.Output != nil {
+			opt.Output = o.Output
+		}
+		if o.Live != nil {
+			opt.Live = o.Live
+		}
+		if o.RefreshInterval > 0 {
+			opt.RefreshInterval = o.RefreshInterval
+		}
+		if o.Width > 0 {
+			opt.Width = o.Width
+		}
+		if len(o.Filter.Types) > 0 || len(o.Filter.ToolCategories) > 0 || len(o.Filter.ExcludeToolCategories) > 0 {
+			opt.Filter = o.Filter
+		}
+	}
+
+	r := &Renderer{
+		out:      opt.Output,
+		interval: opt.RefreshInterval,
+		width:    opt.Width,
+		filter:   opt.Filter,
+		now:      time.Now,
+		calls:    make(gptscript.CallFrames),
+		started:  make(map[string]time.Time),
+		ended:    make(map[string]time.Time),
+	}
+	if r.out == nil {
+		r.out = os.Stdout
+	}
+	if r.interval <= 0 {
+		r.interval = defaultRefreshInterval
+	}
+	if r.width <= 0 {
+		r.width = defaultWidth
+	}
+	if opt.Live != nil {
+		r.live = *opt.Live
+	} else {
+		r.live = isTerminal(r.out)
+	}
+
+	return r
+}
+
+// Render draws the run until all its events are received, and then writes the output of the run. The run must be
+// started with Options.IncludeEvents. Render returns the error of the run, if any.
+func (r *Renderer) Render(ctx context.Context, run *gptscript.Run) error {
+	ctx, cancel := context.WithCancel(ctx)
+	refreshed := make(chan struct{})
+	stopRefresh := func() {
+		cancel()
+		<-refreshed
+	}
+	defer stopRefresh()
+
+	if r.live {
+		go func() {
+			defer close(refreshed)
+			r.refresh(ctx)
+		}()
+	} else {
+		close(refreshed)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-run.Events():
+			if !ok {
+				// The refresh has to stop before the output is written, or it could draw over it.
+				stopRefresh()
+				out, err := run.Text()
+				r.Finish(out, err)
+				return err
+			}
+			r.Handle(event)
+		}
+	}
+}
+
+// Handle draws an event. It can be used with Options.OnEvent or a Subscription, instead of Render.
+// Call Finish once the run is done.
+func (r *Renderer) Handle(event gptscript.Frame) {
+	if event.Call == nil || !r.filter.Matches(event) {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	call := *event.Call
+	if _, ok := r.started[call.ID]; !ok {
+		r.started[call.ID] = r.now()
+		if !r.live {
+			r.printf("%s▶ %s\n", r.indent(call), name(call))
+		}
+	}
+	r.calls[call.ID] = call
+
+	if call.Type == gptscript.EventTypeCallFinish {
+		if _, ok := r.ended[call.ID]; !ok {
+			r.ended[call.ID] = r.now()
+			if !r.live {
+				r.printf("%s✔ %s%s\n", r.indent(call), name(call), r.stats(call.ID, call.Usage))
+			}
+		}
+	}
+}
+
+// Finish draws the final state of the calls, followed by the output or error of the run.
+func (r *Renderer) Finish(out string, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.live {
+		r.draw(true)
+	}
+
+	if out != "" {
+		r.printf("\n%s\n", strings.TrimRight(out, "\n"))
+	}
+	if err != nil {
+		r.printf("\nError: %v\n", err)
+	}
+}
+
+func (r *Renderer) refresh(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.lock.Lock()
+			r.frame++
+			r.draw(false)
+			r.lock.Unlock()
+		}
+	}
+}
+
+// draw redraws the live view over the previous drawing. Calls that are still running are drawn with a spinner, unless
+// final is true.
+func (r *Renderer) draw(final bool) {
+	var sb strings.Builder
+	if r.lines > 0 {
+		// Move the cursor to the start of the previous drawing and clear it.
+		fmt.Fprintf(&sb, "\x1b[%dF\x1b[J", r.lines)
+	}
+
+	var lines int
+	gptscript.NewCallTree(r.calls).Walk(func(node *gptscript.CallNode, depth int) bool {
+		call := node.Call
+
+		status := "✔"
+		if _, ok := r.ended[call.ID]; !ok {
+			status = spinner[r.frame%len(spinner)]
+			if final {
+				status = "•"
+			}
+		}
+
+		line := fmt.Sprintf("%s%s %s%s", strings.Repeat("  ", depth), status, name(call), r.stats(call.ID, call.Usage))
+		if _, ok := r.ended[call.ID]; !ok {
+			if progress := lastLine(call); progress != "" {
+				line += ": " + progress
+			}
+		}
+
+		sb.WriteString(truncate(line, r.width))
+		sb.WriteString("\n")
+		lines++
+		return true
+	})
+
+	r.lines = lines
+	_, _ = io.WriteString(r.out, sb.String())
+}
+
+// stats describes the elapsed time and token usage of the call.
+func (r *Renderer) stats(id string, usage gptscript.Usage) string {
+	end, ok := r.ended[id]
+	if !ok {
+		end = r.now()
+	}
+
+	s := fmt.Sprintf(" (%s", end.Sub(r.started[id]).Round(100*time.Millisecond))
+	if usage.TotalTokens > 0 {
+		s += fmt.Sprintf(", %d tokens", usage.TotalTokens)
+	}
+	return s + ")"
+}
+
+// indent returns the indentation for the call in the line-oriented logs, based on the number of its ancestors that
+// have been seen.
+func (r *Renderer) indent(call gptscript.CallFrame) string {
+	var depth int
+	for parent, ok := r.calls[call.ParentID]; ok && depth < len(r.calls); parent, ok = r.calls[parent.ParentID] {
+		depth++
+	}
+	return strings.Repeat("  ", depth)
+}
+
+func (r *Renderer) printf(format string, args ...any) {
+	_, _ = fmt.Fprintf(r.out, format, args...)
+}
+
+func name(call gptscript.CallFrame) string {
+	if call.DisplayText != "" {
+		return call.DisplayText
+	}
+	if call.ToolName != "" {
+		return call.ToolName
+	}
+	if call.Tool.Name != "" {
+		return call.Tool.Name
+	}
+	return call.ID
+}
+
+// lastLine returns the last non-empty line of the progress output of the call.
+func lastLine(call gptscript.CallFrame) string {
+	if len(call.Output) == 0 {
+		return ""
+	}
+
+	lines := strings.Split(strings.TrimSpace(call.Output[len(call.Output)-1].Content), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
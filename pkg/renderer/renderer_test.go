@@ -0,0 +1,140 @@
+package renderer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gptscript-ai/go-gptscript"
+	"github.com/gptscript-ai/go-gptscript/pkg/gptscripttest"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock advances by a second each time it is read.
+func fakeClock() func() time.Time {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+}
+
+func call(id, parentID, name string, eventType gptscript.EventType, tokens int) gptscript.Frame {
+	return gptscript.Frame{Call: &gptscript.CallFrame{
+		CallContext: gptscript.CallContext{ID: id, ParentID: parentID, ToolName: name},
+		Type:        eventType,
+		Usage:       gptscript.Usage{TotalTokens: tokens},
+	}}
+}
+
+func TestPlainRenderer(t *testing.T) {
+	var buf bytes.Buffer
+	r := New(Options{Output: &buf})
+	r.now = fakeClock()
+
+	r.Handle(gptscript.Frame{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}})
+	r.Handle(call("1", "", "agent", gptscript.EventTypeCallStart, 0))
+	r.Handle(call("2", "1", "search", gptscript.EventTypeCallStart, 0))
+	r.Handle(call("2", "1", "search", gptscript.EventTypeCallProgress, 0))
+	r.Handle(call("2", "1", "search", gptscript.EventTypeCallFinish, 20))
+	r.Handle(call("1", "", "agent", gptscript.EventTypeCallFinish, 100))
+	r.Finish("Hello, World!\n", nil)
+
+	require.Equal(t, `▶ agent
+  ▶ search
+  ✔ search (1s, 20 tokens)
+✔ agent (3s, 100 tokens)
+
+Hello, World!
+`, buf.String())
+}
+
+func TestLiveRenderer(t *testing.T) {
+	var buf bytes.Buffer
+	r := New(Options{Output: &buf, Live: &[]bool{true}[0], Filter: gptscript.EventFilter{
+		ExcludeToolCategories: []gptscript.ToolCategory{gptscript.CredentialToolCategory},
+	}})
+	r.now = fakeClock()
+
+	credential := call("3", "1", "credential", gptscript.EventTypeCallStart, 0)
+	credential.Call.ToolCategory = gptscript.CredentialToolCategory
+
+	r.Handle(call("1", "", "agent", gptscript.EventTypeCallStart, 0))
+	r.Handle(credential)
+	progress := call("2", "1", "search", gptscript.EventTypeCallProgress, 0)
+	progress.Call.Output = []gptscript.Output{{Content: "looking\nfound 3 results"}}
+	r.Handle(progress)
+
+	r.draw(false)
+	require.Equal(t, "⠋ agent (2s)\n  ⠋ search (2s): found 3 results\n", buf.String())
+
+	buf.Reset()
+	r.Handle(call("2", "1", "search", gptscript.EventTypeCallFinish, 20))
+	r.Handle(call("1", "", "agent", gptscript.EventTypeCallFinish, 100))
+	r.Finish("done", errors.New("something failed"))
+
+	// The previous drawing is cleared before the final one.
+	require.True(t, strings.HasPrefix(buf.String(), "\x1b[2F\x1b[J"))
+	require.Equal(t, "✔ agent (5s, 100 tokens)\n  ✔ search (3s, 20 tokens)\n\ndone\n\nError: something failed\n", strings.TrimPrefix(buf.String(), "\x1b[2F\x1b[J"))
+}
+
+func TestTruncate(t *testing.T) {
+	require.Equal(t, "hello", truncate("hello", 5))
+	require.Equal(t, "hel…", truncate("hello", 4))
+}
+
+func TestRender(t *testing.T) {
+	s := gptscripttest.NewServer()
+	defer s.Close()
+	s.Script(gptscripttest.Script{
+		Events: []gptscript.Frame{
+			{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}},
+			call("1", "", "agent", gptscript.EventTypeCallStart, 0),
+			call("1", "", "agent", gptscript.EventTypeCallFinish, 10),
+		},
+		Output: "Hello, World!",
+	})
+
+	g, err := gptscript.NewGPTScript(gptscript.GlobalOptions{URL: s.URL})
+	require.NoError(t, err)
+	defer g.Close()
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{IncludeEvents: true})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	r := New(Options{Output: &buf})
+	r.now = fakeClock()
+	require.NoError(t, r.Render(context.Background(), run))
+	require.Equal(t, "▶ agent\n✔ agent (1s, 10 tokens)\n\nHello, World!\n", buf.String())
+}
+
+func TestRenderLive(t *testing.T) {
+	s := gptscripttest.NewServer()
+	defer s.Close()
+	s.Script(gptscripttest.Script{
+		Events: []gptscript.Frame{
+			{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}},
+			call("1", "", "agent", gptscript.EventTypeCallStart, 0),
+			call("1", "", "agent", gptscript.EventTypeCallFinish, 10),
+		},
+		Delay:  5 * time.Millisecond,
+		Output: "Hello, World!",
+	})
+
+	g, err := gptscript.NewGPTScript(gptscript.GlobalOptions{URL: s.URL})
+	require.NoError(t, err)
+	defer g.Close()
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{IncludeEvents: true})
+	require.NoError(t, err)
+
+	// The view is redrawn often, but never after the output is written.
+	var buf bytes.Buffer
+	r := New(Options{Output: &buf, Live: &[]bool{true}[0], RefreshInterval: time.Microsecond})
+	require.NoError(t, r.Render(context.Background(), run))
+	require.True(t, strings.HasSuffix(buf.String(), "\nHello, World!\n"), buf.String())
+}
@@ -0,0 +1,217 @@
+package gptscript
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordingVersion is the version of the format of recordings.
+const recordingVersion = 1
+
+// recordLine is a line of a recording, which is a JSON object per line. The first line is the header, with the version,
+// the request, and the status code of the response. Each of the other lines is one of the messages of the response's
+// event stream, as JSON Data or, if it isn't JSON, as Raw text, or the Error that ended the stream.
+type recordLine struct {
+	Time time.Time `json:"time"`
+
+	Version    int    `json:"version,omitempty"`
+	Request    string `json:"request,omitempty"`
+	ToolPath   string `json:"toolPath,omitempty"`
+	Prompt     bool   `json:"prompt,omitempty"`
+	StatusCode int    `json:"statusCode,omitempty"`
+
+	Data  json.RawMessage `json:"data,omitempty"`
+	Raw   string          `json:"raw,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// recorder writes the response of a run to a recording.
+type recorder struct {
+	enc    *json.Encoder
+	failed bool
+}
+
+// newRecorder starts a recording of the run's response, if the run has the NewRecording option.
+func newRecorder(r *Run) *recorder {
+	if r.basicCommand {
+		return nil
+	}
+
+	if r.opts.NewRecording == nil {
+		return nil
+	}
+
+	w := r.opts.NewRecording()
+	if w == nil {
+		return nil
+	}
+
+	rec := &recorder{enc: json.NewEncoder(w)}
+	rec.write(recordLine{
+		Version:    recordingVersion,
+		Request:    r.requestPath,
+		ToolPath:   r.toolPath,
+		Prompt:     r.opts.Prompt,
+		StatusCode: r.responseCode,
+	})
+	return rec
+}
+
+// message records a message of the event stream.
+func (rec *recorder) message(data []byte) {
+	if rec == nil || len(data) == 0 {
+		return
+	}
+
+	if json.Valid(data) {
+		rec.write(recordLine{Data: bytes.Clone(data)})
+	} else {
+		rec.write(recordLine{Raw: string(data)})
+	}
+}
+
+// error records the error that ended the event stream.
+func (rec *recorder) error(err error) {
+	if rec == nil {
+		return
+	}
+	rec.write(recordLine{Error: err.Error()})
+}
+
+func (rec *recorder) write(line recordLine) {
+	if rec.failed {
+		return
+	}
+
+	line.Time = time.Now()
+	if err := rec.enc.Encode(line); err != nil {
+		// The run shouldn't fail because it couldn't be recorded, so the rest of it is not recorded.
+		slog.Debug("failed to write recording", "error", err)
+		rec.failed = true
+	}
+}
+
+// Replay returns a Run that replays a recording made with the NewRecording option, without an SDK server. The events,
+// output, chat state, and error of the replayed run are the same as those of the recorded run, and the events are
+// delivered according to opts, like EventDelivery, OnEvent, and Subscriptions. The recording is read entirely before
+// Replay returns, and the replayed run can't be continued with NextChat.
+func Replay(ctx context.Context, recording io.Reader, opts Options) (*Run, error) {
+	var (
+		header recordLine
+		body   bytes.Buffer
+		errMsg string
+	)
+
+	scanner := bufio.NewScanner(recording)
+	scanner.Buffer(nil, 64*1024*1024)
+	for i := 0; scanner.Scan(); i++ {
+		var line recordLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("failed to decode line %d of recording: %w", i+1, err)
+		}
+
+		switch {
+		case i == 0:
+			if line.Version != recordingVersion {
+				return nil, fmt.Errorf("unsupported recording version %d", line.Version)
+			}
+			header = line
+		case line.Version != 0:
+			return nil, fmt.Errorf("line %d of recording starts another run, but a recording can only have one", i+1)
+		case line.Data != nil:
+			writeSSEMessage(&body, line.Data)
+		case line.Raw != "":
+			writeSSEMessage(&body, []byte(line.Raw))
+		case line.Error != "":
+			errMsg = line.Error
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	if header.Version == 0 {
+		return nil, errors.New("recording is empty")
+	}
+
+	// A replayed run isn't recorded again, and it has to handle prompts like the recorded run did.
+	opts.NewRecording = nil
+	opts.Prompt = header.Prompt
+
+	run := &Run{
+		url: "http://replay",
+		client: &http.Client{Transport: &replayTransport{
+			statusCode: header.StatusCode,
+			body:       body.Bytes(),
+			errMsg:     errMsg,
+		}},
+		requestPath: header.Request,
+		toolPath:    header.ToolPath,
+		state:       Creating,
+		opts:        opts,
+	}
+
+	return run, run.request(ctx, nil)
+}
+
+// writeSSEMessage writes the data as a message of an event stream. Each line of the data is a data field, so that
+// decoding the message joins them back with newlines.
+func writeSSEMessage(w *bytes.Buffer, data []byte) {
+	for _, line := range strings.Split(string(data), "\n") {
+		w.WriteString("data: ")
+		w.WriteString(line)
+		w.WriteString("\n")
+	}
+	w.WriteString("\n")
+}
+
+// replayTransport responds to the request of a replayed run with the recorded response. It fails any other requests,
+// like attempts to abort or continue the run.
+type replayTransport struct {
+	lock       sync.Mutex
+	used       bool
+	statusCode int
+	body       []byte
+	errMsg     string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.used {
+		return nil, errors.New("a replayed run can't make requests to the SDK server")
+	}
+	t.used = true
+
+	var body io.Reader = bytes.NewReader(t.body)
+	if t.errMsg != "" {
+		// The stream of the recorded run ended with an error, so the replayed stream does too, after the same messages.
+		body = io.MultiReader(body, errorReader{err: errors.New(t.errMsg)})
+	}
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", t.statusCode, http.StatusText(t.statusCode)),
+		StatusCode: t.statusCode,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(body),
+		Request:    req,
+	}, nil
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,146 @@
+package gptscript
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxResumeAttempts is the number of consecutive attempts to resume an event stream before giving up.
+	maxResumeAttempts = 5
+	// defaultResumeDelay is the wait before each attempt to resume an event stream, unless the stream set another one.
+	defaultResumeDelay = time.Second
+)
+
+// eventStream reads the events of a run. If Options.ResumeStreams is set and the connection to the SDK server is lost,
+// then the stream is resumed from the last event that was received, as long as the server identified its events.
+// The gptscript SDK server doesn't identify its events or serve the "resume/{runID}" endpoint yet, so only servers that
+// do, like the fake server in pkg/gptscripttest with resuming enabled, can resume streams.
+type eventStream struct {
+	run     *Run
+	ctx     context.Context
+	resp    *http.Response
+	decoder *sseDecoder
+	// finished indicates that the end of the stream was received, so there is nothing to resume.
+	finished bool
+	// attempts is the number of attempts to resume the stream since the last event was received.
+	attempts int
+	// onResume is called each time the stream is resumed.
+	onResume func()
+}
+
+func newEventStream(ctx context.Context, run *Run, resp *http.Response) *eventStream {
+	return &eventStream{
+		run:     run,
+		ctx:     ctx,
+		resp:    resp,
+		decoder: newResponseDecoder(resp),
+	}
+}
+
+// next returns the next event of the run. It returns io.EOF when the stream ends.
+func (s *eventStream) next() (sseEvent, error) {
+	for {
+		event, err := s.decoder.next()
+		if err == nil {
+			s.attempts = 0
+			s.finished = s.finished || bytes.Equal(bytes.TrimSpace(event.Data), []byte("[DONE]"))
+			return event, nil
+		}
+
+		// If the stream can't be resumed, then report the error that caused the connection to be lost, rather than why
+		// it couldn't be resumed.
+		if errors.Is(err, io.EOF) || !s.resumable() || !s.resumeAfter(err) {
+			return sseEvent{}, err
+		}
+	}
+}
+
+// resumeAfter tries to resume the stream after reading it failed with the given error, and returns whether it succeeded.
+func (s *eventStream) resumeAfter(err error) bool {
+	slog.Debug("lost connection to event stream, resuming", "run", s.run.id, "lastEventID", s.decoder.lastID, "error", err)
+
+	for s.attempts < maxResumeAttempts {
+		s.attempts++
+
+		delay := s.decoder.retry
+		if delay <= 0 {
+			delay = defaultResumeDelay
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+
+		resp, err := s.resume()
+		if err != nil {
+			slog.Debug("failed to resume event stream", "run", s.run.id, "attempt", s.attempts, "error", err)
+			continue
+		}
+
+		_ = s.resp.Body.Close()
+
+		// The last event ID and reconnection time carry over to the resumed stream.
+		decoder := newResponseDecoder(resp)
+		decoder.lastID, decoder.retry = s.decoder.lastID, s.decoder.retry
+		s.resp, s.decoder = resp, decoder
+
+		if s.onResume != nil {
+			s.onResume()
+		}
+		return true
+	}
+
+	return false
+}
+
+// resumable returns whether the stream can be resumed after losing the connection to the server.
+func (s *eventStream) resumable() bool {
+	return s.run.opts.ResumeStreams &&
+		!s.finished &&
+		!s.decoder.single &&
+		!s.run.basicCommand &&
+		s.run.id != "" &&
+		s.decoder.lastID != "" &&
+		s.ctx.Err() == nil
+}
+
+// resume requests the events of the run after the last event that was received.
+func (s *eventStream) resume() (*http.Response, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, requestURL(s.run.url, "resume/"+s.run.id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", s.decoder.lastID)
+	if s.run.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.run.opts.Token)
+	}
+	s.run.injectTraceContext(s.ctx, req)
+
+	resp, err := s.run.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); resp.StatusCode != http.StatusOK || mediaType != "text/event-stream" {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("unexpected response to resume request: status code %d", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+func (s *eventStream) close() error {
+	return s.resp.Body.Close()
+}
@@ -0,0 +1,89 @@
+package gptscript
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+)
+
+var defaultRetryableStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// RetryPolicy configures the retries of idempotent requests to the SDK server, like Parse, LoadFile, ListCredentials,
+// and reading files in workspaces. Requests that aren't idempotent are never retried, with the exception of
+// WriteFileInWorkspace when LatestRevisionID is set.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one. Retries are disabled if it is less than 2.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry. It doubles for each subsequent retry. The default is 100ms.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum wait between retries. The default is 5s.
+	MaxBackoff time.Duration
+	// Jitter is the fraction of each wait, between 0 and 1, that is randomized.
+	Jitter float64
+	// RetryableStatusCodes are the status codes of responses that are retried. The default is 502, 503, and 504.
+	// Requests that fail without a response, like when the connection is refused, are always retried.
+	RetryableStatusCodes []int
+}
+
+// retryable returns whether a request that failed with the given status code and error should be retried.
+// A status code of zero means that no response was received.
+func (p *RetryPolicy) retryable(statusCode int, err error) bool {
+	if statusCode == 0 {
+		return errors.As(err, new(ErrServerUnavailable))
+	}
+
+	codes := p.RetryableStatusCodes
+	if codes == nil {
+		codes = defaultRetryableStatusCodes
+	}
+	return slices.Contains(codes, statusCode)
+}
+
+// backoff returns the wait before the given retry, starting at 1.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	initial, maxBackoff := p.InitialBackoff, p.MaxBackoff
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	backoff := initial
+	for i := 1; i < retry && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxBackoff)
+
+	if jitter := min(max(p.Jitter, 0), 1); jitter > 0 {
+		backoff -= time.Duration(jitter * rand.Float64() * float64(backoff))
+	}
+
+	return backoff
+}
+
+// runRetryableCommand is like runBasicCommand, but retries the command according to the retry policy.
+// It must only be used for commands that are safe to repeat.
+func (g *GPTScript) runRetryableCommand(ctx context.Context, requestPath string, body any) (string, error) {
+	policy := g.options().RetryPolicy
+	for attempt := 1; ; attempt++ {
+		out, statusCode, err := g.runBasicCommandWithStatus(ctx, requestPath, body)
+		if err == nil || policy == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil || !policy.retryable(statusCode, err) {
+			return out, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return out, err
+		case <-time.After(policy.backoff(attempt)):
+		}
+	}
+}
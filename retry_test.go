@@ -0,0 +1,40 @@
+package gptscript
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	require.Equal(t, time.Second, p.backoff(1))
+	require.Equal(t, 2*time.Second, p.backoff(2))
+	require.Equal(t, 4*time.Second, p.backoff(3))
+	require.Equal(t, 5*time.Second, p.backoff(4))
+	require.Equal(t, 5*time.Second, p.backoff(100))
+
+	p.Jitter = 0.5
+	for range 100 {
+		backoff := p.backoff(2)
+		require.LessOrEqual(t, backoff, 2*time.Second)
+		require.GreaterOrEqual(t, backoff, time.Second)
+	}
+
+	require.Equal(t, defaultInitialBackoff, new(RetryPolicy).backoff(1))
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	p := new(RetryPolicy)
+	require.True(t, p.retryable(http.StatusServiceUnavailable, nil))
+	require.False(t, p.retryable(http.StatusInternalServerError, nil))
+	require.True(t, p.retryable(0, ErrServerUnavailable{RunError{Err: fmt.Errorf("connection refused")}}))
+	require.False(t, p.retryable(0, RunError{Err: fmt.Errorf("failed to create request")}))
+
+	p.RetryableStatusCodes = []int{http.StatusInternalServerError}
+	require.True(t, p.retryable(http.StatusInternalServerError, nil))
+	require.False(t, p.retryable(http.StatusServiceUnavailable, nil))
+}
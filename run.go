@@ -10,23 +10,23 @@ import (
 	"log/slog"
 	"maps"
 	"net/http"
+	"net/url"
 	"os/exec"
 	"strconv"
+	"strings"
 	"sync"
-)
-
-var errAbortRun = errors.New("run aborted")
-
-type ErrNotFound struct {
-	Message string
-}
+	"time"
 
-func (e ErrNotFound) Error() string {
-	return e.Message
-}
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
 
 type Run struct {
 	url, token, requestPath, toolPath string
+	client                            *http.Client
+	tracer                            trace.Tracer
+	propagator                        propagation.TextMapPropagator
+	metrics                           Metrics
 	tools                             []ToolDef
 	opts                              Options
 	state                             RunState
@@ -34,6 +34,7 @@ type Run struct {
 	cancel                            context.CancelCauseFunc
 	err                               error
 	wait                              func()
+	done                              chan struct{}
 	basicCommand                      bool
 
 	program        *Program
@@ -42,7 +43,13 @@ type Run struct {
 	calls          CallFrames
 	rawOutput      map[string]any
 	output, errput string
+	errDetails     []byte
 	events         chan Frame
+	queue          *eventQueue
+	budget         *budgetWatcher
+	trace          *runTrace
+	recorder       *recorder
+	text           *textBuffer
 	lock           sync.Mutex
 	responseCode   int
 }
@@ -67,16 +74,92 @@ func (r *Run) State() RunState {
 }
 
 // Err returns the error that caused the gptscript to fail, if any.
+// The error is one of the types in errors.go, depending on the cause of the failure. All of them can be matched with
+// errors.As to a RunError for the details common to all errors.
 func (r *Run) Err() error {
-	if r.err != nil {
-		if r.responseCode == http.StatusNotFound {
-			return ErrNotFound{
-				Message: fmt.Sprintf("run encountered an error: %s", r.errput),
-			}
+	if r.err == nil {
+		return nil
+	}
+
+	r.callsLock.RLock()
+	failed, hasFailedCall := failedCall(r.calls)
+	runErr := RunError{
+		StatusCode: r.responseCode,
+		Output:     r.errput,
+		RunID:      r.id,
+		CallID:     failed.ID,
+		Details:    r.errDetails,
+		Err:        r.err,
+	}
+	parentCall := r.calls.ParentCallFrame()
+	r.callsLock.RUnlock()
+
+	var budgetErr budgetError
+	switch {
+	case r.responseCode == http.StatusNotFound:
+		return ErrNotFound{
+			Message:  fmt.Sprintf("run encountered an error: %s", r.errput),
+			RunError: runErr,
+		}
+	case r.responseCode == http.StatusUnauthorized || r.responseCode == http.StatusForbidden:
+		return ErrUnauthorized{runErr}
+	case r.responseCode == http.StatusBadRequest || r.responseCode == http.StatusUnprocessableEntity:
+		return ErrBadRequest{runErr}
+	case r.responseCode == http.StatusBadGateway || r.responseCode == http.StatusServiceUnavailable || r.responseCode == http.StatusGatewayTimeout,
+		errors.As(r.err, new(*url.Error)) && !errors.Is(r.err, context.Canceled) && !errors.Is(r.err, context.DeadlineExceeded),
+		errors.Is(r.err, io.ErrUnexpectedEOF):
+		return ErrServerUnavailable{runErr}
+	case errors.As(r.err, &budgetErr):
+		partialOutput := r.output
+		if len(parentCall.Output) > 0 {
+			// The output of the entry tool so far is more useful than the output the server sends for an aborted run.
+			partialOutput = parentCall.Output[len(parentCall.Output)-1].Content
+		}
+		return ErrBudgetExceeded{Limit: budgetErr.limit, PartialOutput: partialOutput, RunError: runErr}
+	case errors.Is(r.err, errAbortRun):
+		return ErrAborted{runErr}
+	case errors.As(r.err, new(runFinishError)):
+		// The run finished with an error, which is only blamed on a call if the events show which call failed and how.
+		switch {
+		case !hasFailedCall:
+			return runErr
+		case failed.ToolCategory == ProviderToolCategory, failed.Type == EventTypeChat && failed.LLMRequest != nil && failed.LLMResponse == nil:
+			// Either the model provider failed, or the call sent a request to the model that was never answered.
+			return ErrModelProvider{runErr}
+		case strings.HasPrefix(failed.Tool.Instructions, "#!"):
+			return ErrToolExecution{runErr}
 		}
-		return fmt.Errorf("run encountered an error: %w with error output: %s", r.err, r.errput)
 	}
-	return nil
+
+	return runErr
+}
+
+// failedCall returns the call that made the run fail, which is the only call that didn't finish and has no calls of its
+// own that didn't finish. The calls it was made by didn't finish either, because its error ended them. If there is more
+// than one such call, like calls made in parallel, then which one failed isn't known.
+func failedCall(calls CallFrames) (CallFrame, bool) {
+	parents := make(map[string]bool)
+	for _, call := range calls {
+		if call.Type != EventTypeCallFinish {
+			parents[call.ParentID] = true
+		}
+	}
+
+	var (
+		failed CallFrame
+		found  bool
+	)
+	for _, call := range calls {
+		if call.Type == EventTypeCallFinish || parents[call.ID] {
+			continue
+		}
+		if found {
+			return CallFrame{}, false
+		}
+		failed, found = call, true
+	}
+
+	return failed, found
 }
 
 // Program returns the gptscript program for the run.
@@ -140,11 +223,43 @@ func (r *Run) ErrorOutput() string {
 }
 
 // Events returns a channel that streams the gptscript events as they occur as Frames.
+// Nothing is sent on the channel if Options.OnEvent is set.
 func (r *Run) Events() <-chan Frame {
 	return r.events
 }
 
-// Close will stop the gptscript run, if it is running.
+// Subscribe registers a handler for the events of the run that match the filter, and returns a function that removes
+// it. Events that arrived before Subscribe was called are not delivered, so use Options.Subscriptions to receive all
+// events. The handler only receives events if the run includes them, through IncludeEvents, OnEvent, Subscriptions, or
+// StreamText.
+func (r *Run) Subscribe(filter EventFilter, handler func(Frame)) func() {
+	if r.queue == nil {
+		return func() {}
+	}
+	return r.queue.subscribe(Subscription{Filter: filter, Handler: handler})
+}
+
+// DroppedEvents returns the number of events that were dropped because of the EventDeliveryDropOldest policy.
+func (r *Run) DroppedEvents() int {
+	if r.queue == nil {
+		return 0
+	}
+	return int(r.queue.dropped.Load())
+}
+
+// Stop stops the gptscript run, if it is running, without waiting for it to stop. Unlike Close, it can be called from
+// Options.OnEvent or a subscription's handler, and the run stops once the handler returns.
+func (r *Run) Stop() error {
+	if r.cancel == nil {
+		return fmt.Errorf("run not started")
+	}
+
+	r.cancel(errAbortRun)
+	return nil
+}
+
+// Close will stop the gptscript run, if it is running, and waits for it to stop. It must not be called from
+// Options.OnEvent or a subscription's handler, because the run can't stop until the handler returns; use Stop instead.
 func (r *Run) Close() error {
 	// If the command was not started, then report error.
 	if r.cancel == nil {
@@ -158,11 +273,12 @@ func (r *Run) Close() error {
 	}
 
 	r.cancel(errAbortRun)
-	if r.wait == nil {
+	if r.done == nil {
 		return nil
 	}
 
-	r.wait()
+	// Wait for the events to stop being processed.
+	<-r.done
 	if !errors.Is(r.err, errAbortRun) && !errors.Is(r.err, context.Canceled) && !errors.As(r.err, new(*exec.ExitError)) {
 		return r.err
 	}
@@ -192,6 +308,10 @@ func (r *Run) NextChat(ctx context.Context, input string) (*Run, error) {
 
 	run := &Run{
 		url:         r.url,
+		client:      r.client,
+		tracer:      r.tracer,
+		propagator:  r.propagator,
+		metrics:     r.metrics,
 		requestPath: r.requestPath,
 		state:       Creating,
 		toolPath:    r.toolPath,
@@ -213,6 +333,10 @@ func (r *Run) NextChat(ctx context.Context, input string) (*Run, error) {
 	// Remove the url and token because they shouldn't be sent with the payload.
 	options.URL = ""
 	options.Token = ""
+	// Events are needed for the callback, subscriptions, the text stream, the budget, and the spans and metrics of the
+	// calls, even if they aren't sent on the events channel.
+	options.IncludeEvents = options.IncludeEvents || options.OnEvent != nil || len(options.Subscriptions) > 0 ||
+		options.StreamText || options.Budget.enabled() || options.TracerProvider != nil || options.Metrics != nil
 	if len(r.tools) != 0 {
 		payload = requestPayload{
 			ToolDefs: r.tools,
@@ -235,9 +359,20 @@ func (r *Run) request(ctx context.Context, payload any) (err error) {
 		return fmt.Errorf("run is in terminal state and cannot be run again: state %q", r.state)
 	}
 
+	if !r.basicCommand && r.tracer != nil {
+		ctx, r.trace = startRunTrace(ctx, r)
+	}
+
+	// Basic commands are recorded as commands by runBasicCommand, rather than as runs.
+	metrics, start := r.metrics, time.Now()
+	if metrics == nil || r.basicCommand {
+		metrics = noMetrics{}
+	}
+	metrics.RunStarted(r.requestPath)
+
 	var (
 		req               *http.Request
-		url               = fmt.Sprintf("%s/%s", r.url, r.requestPath)
+		url               = requestURL(r.url, r.requestPath)
 		cancelCtx, cancel = context.WithCancelCause(ctx)
 	)
 
@@ -245,6 +380,8 @@ func (r *Run) request(ctx context.Context, payload any) (err error) {
 	defer func() {
 		if err != nil {
 			cancel(err)
+			r.trace.end(Usage{}, err)
+			metrics.RunFinished(r.requestPath, Error, time.Since(start))
 		}
 	}()
 
@@ -262,21 +399,23 @@ func (r *Run) request(ctx context.Context, payload any) (err error) {
 	if err != nil {
 		r.state = Error
 		r.err = fmt.Errorf("failed to create request: %w", err)
-		return r.err
+		return r.Err()
 	}
 
 	if r.opts.Token != "" {
 		req.Header.Set("Authorization", "Bearer "+r.opts.Token)
 	}
+	r.injectTraceContext(cancelCtx, req)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := r.httpClient().Do(req)
 	if err != nil {
 		r.state = Error
 		r.err = fmt.Errorf("failed to make request: %w", err)
-		return r.err
+		return r.Err()
 	}
 
 	r.responseCode = resp.StatusCode
+	r.recorder = newRecorder(r)
 	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
 		r.state = Error
 		r.err = fmt.Errorf("run encountered an error: status code %d", resp.StatusCode)
@@ -284,7 +423,15 @@ func (r *Run) request(ctx context.Context, payload any) (err error) {
 		r.state = Running
 	}
 
-	r.events = make(chan Frame, 100)
+	r.queue = newEventQueue(cancelCtx, r.opts)
+	r.budget = newBudgetWatcher(r.opts.Budget, func(err error) {
+		r.abortForBudget(cancelCtx, err)
+	})
+	r.events = r.queue.out
+	if !r.basicCommand {
+		r.text = newTextBuffer()
+	}
+	r.done = make(chan struct{})
 	r.lock.Lock()
 
 	r.wait = func() {
@@ -299,140 +446,174 @@ func (r *Run) request(ctx context.Context, payload any) (err error) {
 
 	go func() {
 		var (
-			err  error
-			frag []byte
+			err error
+			msg sseEvent
 
-			done = true
-			buf  = make([]byte, 64*1024)
+			done   = true
+			stream = newEventStream(cancelCtx, r, resp)
 		)
 		defer func() {
-			resp.Body.Close()
+			stream.close()
 			cancel(r.err)
 			r.wait()
+			r.trace.end(r.Usage(), r.Err())
+			r.text.close(r.output, r.Err())
+			if dropped := r.DroppedEvents(); dropped > 0 {
+				metrics.EventsDropped(dropped)
+			}
+			metrics.RunFinished(r.requestPath, r.state, time.Since(start))
 			r.lock.Unlock()
-			close(r.events)
+			r.queue.close()
+			close(r.done)
 		}()
 
 		r.callsLock.Lock()
 		r.calls = make(map[string]CallFrame)
 		r.callsLock.Unlock()
 
-		for n := 0; n != 0 || err == nil; n, err = resp.Body.Read(buf) {
-			for _, line := range bytes.Split(bytes.TrimSpace(append(frag, buf[:n]...)), []byte("\n\n")) {
-				line = bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data: ")))
-				if len(line) == 0 || bytes.Equal(line, []byte("[DONE]")) {
-					frag = frag[:0]
-					continue
-				}
-
-				// Is this a JSON object?
-				var m map[string]any
-				if err := json.Unmarshal(line, &m); err != nil {
-					// If not, then wait until we get the rest of the output.
-					frag = line[:]
-					continue
-				}
+		stream.onResume = func() {
+			r.queue.send(Frame{Run: &RunFrame{ID: r.id, Type: EventTypeRunReconnected, Start: time.Now()}})
+		}
 
-				frag = frag[:0]
+		for msg, err = stream.next(); err == nil; msg, err = stream.next() {
+			if msg.Type != "message" {
+				slog.Debug("ignoring event of unknown type", "type", msg.Type)
+				continue
+			}
 
-				if out, ok := m["stdout"]; ok {
-					switch out := out.(type) {
-					case string:
-						if unquoted, err := strconv.Unquote(out); err == nil {
-							r.output = unquoted
-						} else {
-							r.output = out
-						}
-					case map[string]any:
-						if r.basicCommand {
-							b, err := json.Marshal(out)
-							if err != nil {
-								r.state = Error
-								r.err = fmt.Errorf("failed to process basic command output: %w", err)
-								return
-							}
-
-							r.output = string(b)
-						}
-						chatState, err := json.Marshal(out["state"])
-						if err != nil {
-							r.state = Error
-							r.err = fmt.Errorf("failed to process chat state: %w", err)
-						}
-						r.chatState = string(chatState)
+			line := bytes.TrimSpace(msg.Data)
+			r.recorder.message(line)
+			if len(line) == 0 || bytes.Equal(line, []byte("[DONE]")) {
+				continue
+			}
 
-						if content, ok := out["content"].(string); ok {
-							r.output = content
-						}
+			var m map[string]any
+			if err := json.Unmarshal(line, &m); err != nil {
+				r.state = Error
+				r.err = fmt.Errorf("failed to decode event %q: %w", line, err)
+				return
+			}
 
-						done, _ = out["done"].(bool)
-						r.rawOutput = out
-					case []any:
+			if out, ok := m["stdout"]; ok {
+				switch out := out.(type) {
+				case string:
+					if unquoted, err := strconv.Unquote(out); err == nil {
+						r.output = unquoted
+					} else {
+						r.output = out
+					}
+				case map[string]any:
+					if r.basicCommand {
 						b, err := json.Marshal(out)
 						if err != nil {
 							r.state = Error
-							r.err = fmt.Errorf("failed to process stdout: %w", err)
+							r.err = fmt.Errorf("failed to process basic command output: %w", err)
 							return
 						}
 
 						r.output = string(b)
-					default:
-						r.state = Error
-						r.err = fmt.Errorf("failed to process stdout, invalid type: %T", out)
-						return
 					}
-				} else if stderr, ok := m["stderr"]; ok {
-					switch out := stderr.(type) {
-					case string:
-						if unquoted, err := strconv.Unquote(out); err == nil {
-							r.errput = unquoted
-						} else {
-							r.errput = out
-						}
-					default:
+					chatState, err := json.Marshal(out["state"])
+					if err != nil {
 						r.state = Error
-						r.err = fmt.Errorf("failed to process stderr, invalid type: %T", out)
+						r.err = fmt.Errorf("failed to process chat state: %w", err)
 					}
-				} else {
-					var event Frame
-					if err := json.Unmarshal(line, &event); err != nil {
-						slog.Debug("failed to unmarshal event", "error", err, "event", string(line))
+					r.chatState = string(chatState)
+
+					if content, ok := out["content"].(string); ok {
+						r.output = content
 					}
 
-					if event.Prompt != nil && !r.opts.Prompt {
+					done, _ = out["done"].(bool)
+					r.rawOutput = out
+				case []any:
+					b, err := json.Marshal(out)
+					if err != nil {
 						r.state = Error
-						r.err = fmt.Errorf("prompt event occurred when prompt was not allowed: %s", event.Prompt)
-						// Ignore the error because it is the same as the above error.
-						_ = r.Close()
-
+						r.err = fmt.Errorf("failed to process stdout: %w", err)
 						return
 					}
 
-					if event.Call != nil {
-						r.callsLock.Lock()
-						r.calls[event.Call.ID] = *event.Call
-						r.callsLock.Unlock()
-					} else if event.Run != nil {
-						if event.Run.Type == EventTypeRunStart {
-							r.callsLock.Lock()
-							r.program = &event.Run.Program
-							r.id = event.Run.ID
-							r.callsLock.Unlock()
-						} else if event.Run.Type == EventTypeRunFinish && event.Run.Error != "" {
-							r.state = Error
-							r.err = fmt.Errorf("%s", event.Run.Error)
-						}
+					r.output = string(b)
+				default:
+					r.state = Error
+					r.err = fmt.Errorf("failed to process stdout, invalid type: %T", out)
+					return
+				}
+			} else if stderr, ok := m["stderr"]; ok {
+				switch out := stderr.(type) {
+				case string:
+					if unquoted, err := strconv.Unquote(out); err == nil {
+						r.errput = unquoted
+					} else {
+						r.errput = out
 					}
+				default:
+					r.state = Error
+					r.err = fmt.Errorf("failed to process stderr, invalid type: %T", out)
+				}
+
+				// Newer SDK servers also return a structured error for some commands.
+				if details, ok := m["error"]; ok {
+					r.errDetails, _ = json.Marshal(details)
+				}
+			} else {
+				var event Frame
+				if err := json.Unmarshal(line, &event); err != nil {
+					r.state = Error
+					r.err = fmt.Errorf("failed to decode event %q: %w", line, err)
+					return
+				}
+
+				if event.Prompt != nil && !r.opts.Prompt {
+					r.state = Error
+					r.err = fmt.Errorf("prompt event occurred when prompt was not allowed: %s", event.Prompt)
+					r.cancel(r.err)
+
+					return
+				}
 
-					if r.opts.IncludeEvents {
-						r.events <- event
+				if event.Call != nil {
+					r.callsLock.Lock()
+					r.calls[event.Call.ID] = *event.Call
+					r.callsLock.Unlock()
+					r.budget.check(r.calls)
+					r.trace.call(*event.Call)
+					r.text.call(*event.Call)
+					if event.Call.Type == EventTypeCallFinish {
+						recordCall(metrics, *event.Call, r.opts.DefaultModel)
+					}
+				} else if event.Run != nil {
+					if event.Run.Type == EventTypeRunStart {
+						r.callsLock.Lock()
+						r.program = &event.Run.Program
+						r.id = event.Run.ID
+						r.callsLock.Unlock()
+						r.trace.runStarted(event.Run.ID)
+					} else if event.Run.Type == EventTypeRunFinish && event.Run.Error != "" {
+						r.state = Error
+						r.err = runFinishError{message: event.Run.Error}
 					}
 				}
+
+				r.queue.send(event)
 			}
 		}
 
 		if !errors.Is(err, io.EOF) {
+			r.recorder.error(err)
+		}
+
+		r.budget.stop()
+		if budgetErr := r.budget.Err(); budgetErr != nil {
+			// The run was aborted because it exceeded its budget, so that is the error regardless of how the run ended.
+			r.err = budgetErr
+		} else if !errors.Is(err, io.EOF) {
 			slog.Debug("failed to read events from response", "error", err)
+			if cancelCtx.Err() != nil {
+				// Reading failed because the run was canceled, so report why it was canceled.
+				err = context.Cause(cancelCtx)
+			}
 			r.err = fmt.Errorf("failed to read events: %w", err)
 		}
 
@@ -448,6 +629,13 @@ func (r *Run) request(ctx context.Context, payload any) (err error) {
 	return nil
 }
 
+func (r *Run) httpClient() *http.Client {
+	if r.client == nil {
+		return http.DefaultClient
+	}
+	return r.client
+}
+
 type RunState string
 
 func (rs RunState) IsTerminal() bool {
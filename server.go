@@ -0,0 +1,417 @@
+package gptscript
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minRestartBackoff = 250 * time.Millisecond
+	maxRestartBackoff = 30 * time.Second
+)
+
+// defaultServerManager is shared by all GPTScript instances that don't specify their own ServerManager.
+var defaultServerManager = &ServerManager{useEnvURL: true}
+
+type ServerState string
+
+const (
+	ServerStopped    ServerState = "stopped"
+	ServerRunning    ServerState = "running"
+	ServerRestarting ServerState = "restarting"
+)
+
+type ServerEventType string
+
+const (
+	// ServerEventStarted is emitted when the server is started for the first GPTScript instance using it.
+	ServerEventStarted ServerEventType = "started"
+	// ServerEventExited is emitted when the server exits unexpectedly. A restart is attempted after it.
+	ServerEventExited ServerEventType = "exited"
+	// ServerEventRestarted is emitted when the server is running again after exiting unexpectedly.
+	ServerEventRestarted ServerEventType = "restarted"
+	// ServerEventRestartFailed is emitted for each failed restart attempt. Another attempt is made after a backoff.
+	ServerEventRestartFailed ServerEventType = "restartFailed"
+	// ServerEventStopped is emitted when the server is stopped because the last GPTScript instance using it was closed.
+	ServerEventStopped ServerEventType = "stopped"
+)
+
+// ServerEvent describes a change in the health of a server managed by a ServerManager.
+type ServerEvent struct {
+	Type     ServerEventType
+	Time     time.Time
+	URL      string
+	Restarts int
+	Err      error
+}
+
+// ServerManager manages an SDK server process. The process is started when the first GPTScript instance using the
+// manager is created, and stopped when the last one is closed. If the process exits unexpectedly in between, it is
+// restarted with backoff.
+type ServerManager struct {
+	// env is the environment of the server process. If it is nil, then the environment of the first GPTScript
+	// instance using this manager is used.
+	env []string
+	// useEnvURL indicates that the GPTSCRIPT_URL environment variable should be used instead of starting a server.
+	useEnvURL bool
+	// socket is the path of the Unix domain socket the server listens on. If it is empty, then the socket of the
+	// first GPTScript instance using this manager is used, if any.
+	socket string
+	logs   serverLogs
+
+	lock     sync.Mutex
+	count    int
+	url      string
+	state    ServerState
+	restarts int
+	cancel   context.CancelFunc
+	done     chan struct{}
+	// stopping is closed once the server that is being stopped by release has exited.
+	stopping chan struct{}
+	handlers []func(ServerEvent)
+}
+
+// NewServerManager creates a ServerManager that can be shared by GPTScript instances using GlobalOptions.ServerManager.
+// The server process is started with the environment built from the given options, regardless of the options of the
+// GPTScript instances using it.
+func NewServerManager(opts ...GlobalOptions) *ServerManager {
+	opt := completeGlobalOptions(opts...)
+	if opt.Env == nil {
+		opt.Env = os.Environ()
+	}
+
+	return newServerManager(append(opt.Env, opt.toEnv()...), opt)
+}
+
+func newServerManager(env []string, opt GlobalOptions) *ServerManager {
+	m := &ServerManager{env: env, socket: opt.ServerSocket}
+	m.logs.setLogger(opt.ServerLogger)
+	return m
+}
+
+// URL returns the URL of the server, or an empty string if the server is not running.
+// The URL can change when the server is restarted.
+func (m *ServerManager) URL() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.url
+}
+
+// State returns the current state of the server.
+func (m *ServerManager) State() ServerState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.state == "" {
+		return ServerStopped
+	}
+	return m.state
+}
+
+// OnEvent registers a function that is called with each health event of the server.
+// The function is called synchronously by the supervisor of the server, so it should not block.
+func (m *ServerManager) OnEvent(fn func(ServerEvent)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.handlers = append(m.handlers, fn)
+}
+
+func (m *ServerManager) emit(event ServerEvent) {
+	m.lock.Lock()
+	handlers := m.handlers
+	event.Restarts = m.restarts
+	m.lock.Unlock()
+
+	event.Time = time.Now()
+	for _, h := range handlers {
+		h(event)
+	}
+}
+
+// Logs returns the most recent lines the server wrote to stderr, including those from before any restarts.
+func (m *ServerManager) Logs() []ServerLogEntry {
+	return m.logs.list()
+}
+
+// acquire returns the URL of the server, starting it if necessary. Every call to acquire must be paired with a call
+// to release. The environment, logger, and socket from the given options are only used if the manager wasn't created
+// with its own.
+func (m *ServerManager) acquire(opt GlobalOptions) (string, error) {
+	m.lock.Lock()
+
+	// A server that is being stopped can't be used, so wait for it to exit before starting another one.
+	for m.stopping != nil {
+		stopping := m.stopping
+		m.lock.Unlock()
+		<-stopping
+		m.lock.Lock()
+		if m.stopping == stopping {
+			m.stopping = nil
+		}
+	}
+
+	if m.url == "" && m.useEnvURL {
+		m.url = os.Getenv("GPTSCRIPT_URL")
+	}
+
+	if m.cancel != nil || m.url != "" {
+		m.count++
+		defer m.lock.Unlock()
+		return m.url, nil
+	}
+
+	env := m.env
+	if env == nil {
+		env = opt.Env
+		m.logs.setLogger(opt.ServerLogger)
+	}
+
+	addr := "127.0.0.1:0"
+	if socket := firstSet(m.socket, opt.ServerSocket); socket != "" {
+		addr = "unix://" + socket
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := startServerProcess(ctx, env, addr, &m.logs)
+	if err != nil {
+		cancel()
+		m.lock.Unlock()
+		return "", err
+	}
+
+	m.count++
+	m.url = p.url
+	m.state = ServerRunning
+	m.restarts = 0
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	go m.supervise(ctx, m.done, p, env)
+	m.lock.Unlock()
+
+	m.emit(ServerEvent{Type: ServerEventStarted, URL: p.url})
+	return p.url, nil
+}
+
+// release stops the server when it is no longer used by any GPTScript instance.
+func (m *ServerManager) release() {
+	m.lock.Lock()
+	m.count--
+	if m.count != 0 || m.cancel == nil {
+		m.lock.Unlock()
+		return
+	}
+
+	// The server is stopped before the lock is released, so that acquire doesn't return its URL, and the supervisor
+	// doesn't restart it.
+	done, url := m.done, m.url
+	m.cancel()
+	m.cancel, m.done = nil, nil
+	m.url = ""
+	m.state = ServerStopped
+	m.stopping = done
+	m.lock.Unlock()
+
+	<-done
+
+	m.lock.Lock()
+	if m.stopping == done {
+		m.stopping = nil
+	}
+	m.lock.Unlock()
+
+	m.emit(ServerEvent{Type: ServerEventStopped, URL: url})
+}
+
+// supervise waits for the server process to exit and restarts it, until the context is canceled.
+func (m *ServerManager) supervise(ctx context.Context, done chan struct{}, p *serverProcess, env []string) {
+	defer close(done)
+
+	for {
+		<-p.exited
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.lock.Lock()
+		if ctx.Err() != nil {
+			// The server was stopped by release, which cancels the context while holding the lock.
+			m.lock.Unlock()
+			return
+		}
+		m.state = ServerRestarting
+		m.lock.Unlock()
+		m.emit(ServerEvent{Type: ServerEventExited, URL: p.url, Err: p.err})
+
+		var (
+			err     error
+			addr    = p.url
+			backoff = minRestartBackoff
+		)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			// Try to restart the server on the same address so that the URL doesn't change.
+			if p, err = startServerProcess(ctx, env, addr, &m.logs); err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if !strings.HasPrefix(addr, "unix://") {
+				addr = "127.0.0.1:0"
+			}
+
+			m.emit(ServerEvent{Type: ServerEventRestartFailed, Err: err})
+			backoff = min(2*backoff, maxRestartBackoff)
+		}
+
+		m.lock.Lock()
+		if ctx.Err() != nil {
+			m.lock.Unlock()
+			return
+		}
+		m.url = p.url
+		m.state = ServerRunning
+		m.restarts++
+		m.lock.Unlock()
+		m.emit(ServerEvent{Type: ServerEventRestarted, URL: p.url})
+	}
+}
+
+type serverProcess struct {
+	url    string
+	exited chan struct{}
+	err    error
+}
+
+// startServerProcess starts the SDK server listening on the given address, which is either a host and port or a unix://
+// URL. The process is killed when the context is canceled. Everything the server writes to stderr after its address
+// is added to the logs.
+func startServerProcess(ctx context.Context, env []string, addr string, logs *serverLogs) (*serverProcess, error) {
+	if socket, ok := strings.CutPrefix(addr, "unix://"); ok {
+		if err := removeStaleSocket(socket); err != nil {
+			return nil, err
+		}
+	}
+
+	// The server exits when its stdin is closed, which happens when this process exits or the context is canceled.
+	in, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, getCommand(), "sys.sdkserver", "--listen-address", addr)
+	cmd.Env = env[:]
+	cmd.Stdin = in
+
+	// Use a pipe instead of cmd.StderrPipe so that the logs written right before the server exits can still be read.
+	stdErr, stdErrWriter, err := os.Pipe()
+	if err != nil {
+		_ = in.Close()
+		_ = stdinWriter.Close()
+		return nil, fmt.Errorf("failed to get stderr pipe: %w", err)
+	}
+	cmd.Stderr = stdErrWriter
+
+	err = cmd.Start()
+	_ = in.Close()
+	_ = stdErrWriter.Close()
+	if err != nil {
+		_ = stdinWriter.Close()
+		_ = stdErr.Close()
+		return nil, fmt.Errorf("failed to start server: %w", err)
+	}
+
+	p := &serverProcess{exited: make(chan struct{})}
+	go func() {
+		defer close(p.exited)
+		p.err = cmd.Wait()
+		if p.err == nil {
+			p.err = errors.New("server exited")
+		}
+		_ = stdinWriter.Close()
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = stdinWriter.Close()
+		case <-p.exited:
+		}
+	}()
+
+	stdErrReader := bufio.NewReader(stdErr)
+	url, err := readAddress(stdErrReader)
+	if err != nil {
+		_ = cmd.Process.Kill()
+		<-p.exited
+		_ = stdErr.Close()
+		return nil, fmt.Errorf("failed to read server URL: %w", err)
+	}
+
+	go func() {
+		defer stdErr.Close()
+
+		// Ensure that stdErr is drained as logs come in. The pipe is closed once the server exits.
+		for {
+			line, err := stdErrReader.ReadString('\n')
+			logs.add(line)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	p.url = strings.TrimSpace(url)
+	if strings.HasPrefix(addr, "unix://") {
+		p.url = addr
+	}
+
+	return p, nil
+}
+
+// removeStaleSocket removes the socket left behind by a server that exited unexpectedly, otherwise the new server can't
+// listen on it. A socket that accepts connections belongs to a running server, so it is an error to start another one on
+// it.
+func removeStaleSocket(socket string) error {
+	if fi, err := os.Stat(socket); err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+
+	if conn, err := net.DialTimeout("unix", socket, time.Second); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("socket %s is already in use by another server", socket)
+	}
+
+	if err := os.Remove(socket); err != nil {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	return nil
+}
+
+func readAddress(stdErr io.Reader) (string, error) {
+	addr, err := bufio.NewReader(stdErr).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read server address: %w", err)
+	}
+
+	if _, url, found := strings.Cut(addr, "addr="); found {
+		// For backward compatibility: older versions of the SDK server print the address in a slightly different way.
+		addr = url
+	}
+
+	return addr, nil
+}
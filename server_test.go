@@ -0,0 +1,273 @@
+package gptscript
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServerBin writes a script that behaves like the SDK server: it prints an address unique to the process, records
+// its environment and arguments, and then waits to be killed. It returns a function that reads the environment of the server
+// listening at the given URL.
+func fakeServerBin(t *testing.T) func(url string) []string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake server script requires a POSIX shell")
+	}
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "gptscript")
+	require.NoError(t, os.WriteFile(bin, []byte("#!/bin/sh\nenv > \"$(dirname \"$0\")/env.$$\"\necho \"ARGS=$*\" >> \"$(dirname \"$0\")/env.$$\"\necho \"127.0.0.1:$$\" >&2\necho 'time=\"2024-01-01T00:00:00Z\" level=warning msg=\"server started\" component=sdkserver' >&2\nexec sleep 60\n"), 0o755))
+	t.Setenv("GPTSCRIPT_BIN", bin)
+
+	return func(url string) []string {
+		t.Helper()
+
+		_, pid, _ := strings.Cut(strings.TrimPrefix(url, "http://"), ":")
+		env, err := os.ReadFile(filepath.Join(dir, "env."+pid))
+		require.NoError(t, err)
+
+		return strings.Split(string(env), "\n")
+	}
+}
+
+func TestIsolatedServer(t *testing.T) {
+	serverEnv := fakeServerBin(t)
+
+	g1, err := NewGPTScript(GlobalOptions{IsolatedServer: true, OpenAIAPIKey: "key1"})
+	require.NoError(t, err)
+	defer g1.Close()
+
+	g2, err := NewGPTScript(GlobalOptions{IsolatedServer: true, OpenAIAPIKey: "key2"})
+	require.NoError(t, err)
+	defer g2.Close()
+
+	require.NotEqual(t, g1.URL(), g2.URL())
+	require.Contains(t, serverEnv(g1.URL()), "OPENAI_API_KEY=key1")
+	require.Contains(t, serverEnv(g2.URL()), "OPENAI_API_KEY=key2")
+}
+
+func TestSharedServerManager(t *testing.T) {
+	serverEnv := fakeServerBin(t)
+
+	m := NewServerManager(GlobalOptions{DefaultModel: "my-model"})
+
+	g1, err := NewGPTScript(GlobalOptions{ServerManager: m})
+	require.NoError(t, err)
+
+	g2, err := NewGPTScript(GlobalOptions{ServerManager: m, DefaultModel: "other-model"})
+	require.NoError(t, err)
+
+	require.Equal(t, g1.URL(), g2.URL())
+	require.Equal(t, "http://"+m.URL(), g1.URL())
+	require.Contains(t, serverEnv(g1.URL()), "GPTSCRIPT_SDKSERVER_DEFAULT_MODEL=my-model")
+	require.NotContains(t, serverEnv(g1.URL()), "GPTSCRIPT_SDKSERVER_DEFAULT_MODEL=other-model")
+
+	g1.Close()
+	require.NotEmpty(t, m.URL())
+	require.Equal(t, ServerRunning, m.State())
+
+	g2.Close()
+	require.Empty(t, m.URL())
+	require.Equal(t, ServerStopped, m.State())
+}
+
+func TestServerManagerConcurrentUse(t *testing.T) {
+	fakeServerBin(t)
+
+	m := NewServerManager()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for range 20 {
+				g, err := NewGPTScript(GlobalOptions{ServerManager: m})
+				if err != nil {
+					t.Errorf("failed to create gptscript: %v", err)
+					return
+				}
+
+				// The server can't be stopped while this instance is using it, including by a release that was in progress
+				// when the instance was created.
+				time.Sleep(time.Millisecond)
+				if state, url := m.State(), m.URL(); state != ServerRunning || g.URL() != "http://"+url {
+					t.Errorf("instance uses %s, but the server is %s at %s", g.URL(), state, url)
+				}
+				g.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, ServerStopped, m.State())
+	require.Empty(t, m.URL())
+	require.Zero(t, m.count)
+}
+
+func TestServerRestart(t *testing.T) {
+	fakeServerBin(t)
+
+	events := make(chan ServerEvent, 10)
+	m := NewServerManager()
+	m.OnEvent(func(e ServerEvent) {
+		events <- e
+	})
+
+	g, err := NewGPTScript(GlobalOptions{ServerManager: m})
+	require.NoError(t, err)
+
+	require.Equal(t, ServerEventStarted, (<-events).Type)
+
+	url := g.URL()
+	pid, err := strconv.Atoi(strings.TrimPrefix(url, "http://127.0.0.1:"))
+	require.NoError(t, err)
+
+	p, err := os.FindProcess(pid)
+	require.NoError(t, err)
+	require.NoError(t, p.Kill())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, eventType := range []ServerEventType{ServerEventExited, ServerEventRestarted} {
+		select {
+		case e := <-events:
+			require.Equal(t, eventType, e.Type)
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %s event", eventType)
+		}
+	}
+
+	require.Equal(t, ServerRunning, m.State())
+	require.NotEqual(t, url, g.URL())
+	require.Contains(t, g.options().Env, "GPTSCRIPT_URL="+g.URL())
+	require.NotContains(t, g.options().Env, "GPTSCRIPT_URL="+url)
+
+	g.Close()
+	require.Equal(t, ServerEventStopped, (<-events).Type)
+}
+
+func TestServerSocket(t *testing.T) {
+	fakeServerBin(t)
+
+	socket := filepath.Join(t.TempDir(), "gptscript.sock")
+	m := NewServerManager(GlobalOptions{ServerSocket: socket})
+
+	g, err := NewGPTScript(GlobalOptions{ServerManager: m})
+	require.NoError(t, err)
+	defer g.Close()
+
+	require.Equal(t, "unix://"+socket, g.URL())
+	require.Contains(t, g.options().Env, "GPTSCRIPT_URL=unix://"+socket)
+	require.Equal(t, "http://unix/run", requestURL(g.URL(), "run"))
+}
+
+func TestUnixSocketTransport(t *testing.T) {
+	g := &GPTScript{}
+	client := g.httpClient(GlobalOptions{URL: "unix:///tmp/gptscript.sock"})
+	require.Same(t, client.Transport, g.httpClient(GlobalOptions{URL: "unix:///tmp/gptscript.sock"}).Transport)
+	require.NotSame(t, client.Transport, g.httpClient(GlobalOptions{URL: "unix:///tmp/other.sock"}).Transport)
+	require.NotSame(t, client.Transport, (&GPTScript{}).httpClient(GlobalOptions{URL: "unix:///tmp/gptscript.sock"}).Transport)
+
+	// The transports aren't kept after the instance is closed.
+	g.Close()
+	require.Empty(t, g.transports)
+}
+
+func TestServerSocketInUse(t *testing.T) {
+	fakeServerBin(t)
+
+	socket := filepath.Join(t.TempDir(), "gptscript.sock")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, err = NewGPTScript(GlobalOptions{IsolatedServer: true, ServerSocket: socket})
+	require.ErrorContains(t, err, "already in use")
+
+	// The socket of the running server must not be removed.
+	conn, err := net.Dial("unix", socket)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+}
+
+func TestServerStaleSocket(t *testing.T) {
+	fakeServerBin(t)
+
+	socket := filepath.Join(t.TempDir(), "gptscript.sock")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, l.Close())
+
+	g, err := NewGPTScript(GlobalOptions{IsolatedServer: true, ServerSocket: socket})
+	require.NoError(t, err)
+	defer g.Close()
+
+	// The fake server doesn't listen on the socket, so the stale one was removed and not replaced.
+	require.Equal(t, "unix://"+socket, g.URL())
+	_, err = os.Stat(socket)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestServerLogs(t *testing.T) {
+	fakeServerBin(t)
+
+	var buf syncBuffer
+	g, err := NewGPTScript(GlobalOptions{
+		IsolatedServer: true,
+		ServerLogger:   slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{ReplaceAttr: removeTime})),
+	})
+	require.NoError(t, err)
+	defer g.Close()
+
+	require.Eventually(t, func() bool {
+		return buf.String() != ""
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, g.ServerLogs(), 1)
+
+	entry := g.ServerLogs()[0]
+	require.Equal(t, slog.LevelWarn, entry.Level)
+	require.Equal(t, "server started", entry.Message)
+	require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), entry.Time)
+	require.Equal(t, []slog.Attr{slog.Any("component", "sdkserver")}, entry.Attrs)
+	require.Equal(t, "level=WARN msg=\"server started\" component=sdkserver\n", buf.String())
+}
+
+func removeTime(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.TimeKey && len(groups) == 0 {
+		return slog.Attr{}
+	}
+	return a
+}
+
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
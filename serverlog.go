@@ -0,0 +1,169 @@
+package gptscript
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serverLogBufferSize is the number of log lines from the SDK server that are kept in memory.
+const serverLogBufferSize = 1000
+
+// ServerLogEntry is a line that the SDK server wrote to its stderr.
+type ServerLogEntry struct {
+	Time    time.Time
+	Level   slog.Level
+	Message string
+	Attrs   []slog.Attr
+	// Line is the line as written by the server.
+	Line string
+}
+
+func (e ServerLogEntry) String() string {
+	return e.Line
+}
+
+// serverLogs forwards the lines written by the SDK server to a logger and keeps the most recent ones in memory.
+type serverLogs struct {
+	lock    sync.Mutex
+	logger  *slog.Logger
+	entries []ServerLogEntry
+	next    int
+}
+
+func (l *serverLogs) setLogger(logger *slog.Logger) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.logger == nil {
+		l.logger = logger
+	}
+}
+
+func (l *serverLogs) add(line string) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+
+	entry := parseServerLogLine(line)
+
+	l.lock.Lock()
+	if len(l.entries) < serverLogBufferSize {
+		l.entries = append(l.entries, entry)
+	} else {
+		l.entries[l.next] = entry
+		l.next = (l.next + 1) % serverLogBufferSize
+	}
+	logger := l.logger
+	l.lock.Unlock()
+
+	if logger != nil {
+		logger.LogAttrs(context.Background(), entry.Level, entry.Message, entry.Attrs...)
+	}
+}
+
+// list returns the entries in the order they were written.
+func (l *serverLogs) list() []ServerLogEntry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	entries := make([]ServerLogEntry, 0, len(l.entries))
+	entries = append(entries, l.entries[l.next:]...)
+	return append(entries, l.entries[:l.next]...)
+}
+
+// parseServerLogLine parses a JSON or logfmt line into an entry. Lines that aren't structured are logged at the info
+// level with the whole line as the message.
+func parseServerLogLine(line string) ServerLogEntry {
+	entry := ServerLogEntry{
+		Time:    time.Now(),
+		Level:   slog.LevelInfo,
+		Message: line,
+		Line:    line,
+	}
+
+	var fields map[string]any
+	if strings.HasPrefix(line, "{") {
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			return entry
+		}
+	} else if fields = parseLogfmt(line); fields["msg"] == nil && fields["level"] == nil {
+		return entry
+	}
+
+	var attrs []slog.Attr
+	for k, v := range fields {
+		switch k {
+		case "msg", "message":
+			entry.Message = fmt.Sprint(v)
+		case "level", "lvl":
+			entry.Level = parseLevel(fmt.Sprint(v))
+		case "time", "ts":
+			if s, ok := v.(string); ok {
+				if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+					entry.Time = t
+					continue
+				}
+			}
+			attrs = append(attrs, slog.Any(k, v))
+		default:
+			attrs = append(attrs, slog.Any(k, v))
+		}
+	}
+
+	// Map iteration order is random, so sort the attributes for consistent output.
+	slices.SortFunc(attrs, func(a, b slog.Attr) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	entry.Attrs = attrs
+	return entry
+}
+
+func parseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "trace", "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error", "fatal", "panic":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// parseLogfmt parses key=value pairs, where values may be quoted. Anything that isn't a key=value pair is ignored.
+func parseLogfmt(line string) map[string]any {
+	fields := make(map[string]any)
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		key, rest, found := strings.Cut(line, "=")
+		if !found || key == "" || strings.ContainsAny(key, " \"") {
+			// Skip to the next space-separated token.
+			_, line, _ = strings.Cut(line, " ")
+			continue
+		}
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return fields
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			value, rest, _ = strings.Cut(rest, " ")
+		}
+
+		fields[key] = value
+		line = rest
+	}
+
+	return fields
+}
@@ -0,0 +1,68 @@
+package gptscript
+
+import (
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseServerLogLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		level   slog.Level
+		message string
+		attrs   []slog.Attr
+	}{
+		{
+			name:    "unstructured",
+			line:    "listening on 127.0.0.1:1234",
+			level:   slog.LevelInfo,
+			message: "listening on 127.0.0.1:1234",
+		},
+		{
+			name:    "logfmt",
+			line:    `time="2024-01-01T00:00:00Z" level=error msg="failed to run tool" tool=sys.exec error="exit status 1"`,
+			level:   slog.LevelError,
+			message: "failed to run tool",
+			attrs:   []slog.Attr{slog.Any("error", "exit status 1"), slog.Any("tool", "sys.exec")},
+		},
+		{
+			name:    "json",
+			line:    `{"level":"debug","msg":"calling model","model":"gpt-4o","time":"2024-01-01T00:00:00Z"}`,
+			level:   slog.LevelDebug,
+			message: "calling model",
+			attrs:   []slog.Attr{slog.Any("model", "gpt-4o")},
+		},
+		{
+			name:    "invalid json",
+			line:    `{"level":"debug"`,
+			level:   slog.LevelInfo,
+			message: `{"level":"debug"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := parseServerLogLine(tt.line)
+			require.Equal(t, tt.level, entry.Level)
+			require.Equal(t, tt.message, entry.Message)
+			require.Equal(t, tt.attrs, entry.Attrs)
+			require.Equal(t, tt.line, entry.String())
+		})
+	}
+}
+
+func TestServerLogsRingBuffer(t *testing.T) {
+	var logs serverLogs
+	for i := range serverLogBufferSize + 10 {
+		logs.add(fmt.Sprintf("line %d\n", i))
+	}
+
+	entries := logs.list()
+	require.Len(t, entries, serverLogBufferSize)
+	require.Equal(t, "line 10", entries[0].Message)
+	require.Equal(t, fmt.Sprintf("line %d", serverLogBufferSize+9), entries[len(entries)-1].Message)
+}
@@ -0,0 +1,191 @@
+package gptscript
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// sseEvent is an event from a Server-Sent Events stream.
+type sseEvent struct {
+	// ID is the last event ID of the stream when the event was dispatched.
+	ID string
+	// Type is the type of the event, which is "message" if the event didn't set one.
+	Type string
+	// Data is the data of the event, with the data lines joined by newlines.
+	Data []byte
+}
+
+// sseDecoder decodes the events in a Server-Sent Events stream, as described in
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation.
+// Lines can be of any length and may end with CRLF, LF, or CR.
+type sseDecoder struct {
+	r *bufio.Reader
+	// single indicates that the stream isn't an event stream, so all of it is decoded as the data of a single event.
+	single bool
+	// skipLF indicates that the previous line ended with a CR, so an LF that immediately follows it is part of the same
+	// line ending.
+	skipLF bool
+
+	// lastID is the ID of the last event, which is kept until the stream sets another one.
+	lastID string
+	// retry is the reconnection time set by the stream, if any.
+	retry time.Duration
+}
+
+func newSSEDecoder(r io.Reader) *sseDecoder {
+	return &sseDecoder{r: bufio.NewReader(r)}
+}
+
+// sseFieldPrefixes are the starts of the lines of an event stream, which are used to recognize an event stream that isn't
+// labeled as one.
+var sseFieldPrefixes = [][]byte{[]byte("data:"), []byte("id:"), []byte("event:"), []byte("retry:"), []byte(":")}
+
+// newResponseDecoder returns a decoder for the body of the response. Responses that aren't event streams, like the
+// responses to basic commands, are decoded as a single event. The Content-Type header can be missing or rewritten, like
+// by a proxy or an older SDK server, so a body that starts like an event stream is decoded as one regardless.
+func newResponseDecoder(resp *http.Response) *sseDecoder {
+	d := newSSEDecoder(resp.Body)
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	d.single = mediaType != "text/event-stream" && !d.startsWithField()
+	return d
+}
+
+// startsWithField returns whether the stream starts with a field or a comment of an event stream. It only reads as much
+// of the stream as it needs to decide, so that it doesn't wait for more events.
+func (d *sseDecoder) startsWithField() bool {
+	for n := 1; ; n++ {
+		buf, err := d.r.Peek(n)
+		if len(buf) < n {
+			return false
+		}
+
+		var undecided bool
+		for _, prefix := range sseFieldPrefixes {
+			if bytes.HasPrefix(buf, prefix) {
+				return true
+			}
+			undecided = undecided || bytes.HasPrefix(prefix, buf)
+		}
+		if !undecided || err != nil {
+			return false
+		}
+	}
+}
+
+// next returns the next event of the stream. It returns io.EOF when the stream ends, and drops an event that wasn't
+// terminated by a blank line before the end of the stream, as the specification requires.
+func (d *sseDecoder) next() (sseEvent, error) {
+	if d.single {
+		return d.nextSingle()
+	}
+
+	var (
+		event   sseEvent
+		data    bytes.Buffer
+		hasData bool
+	)
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			return sseEvent{}, err
+		}
+
+		if len(line) == 0 {
+			// A blank line dispatches the event, unless it has no data.
+			if !hasData {
+				event = sseEvent{}
+				continue
+			}
+
+			event.ID = d.lastID
+			if event.Type == "" {
+				event.Type = "message"
+			}
+			event.Data = data.Bytes()
+			return event, nil
+		}
+
+		if line[0] == ':' {
+			// Lines starting with a colon are comments, which servers use to keep connections alive.
+			continue
+		}
+
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+
+		switch string(field) {
+		case "event":
+			event.Type = string(value)
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.Write(value)
+			hasData = true
+		case "id":
+			if bytes.IndexByte(value, 0) == -1 {
+				d.lastID = string(value)
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(string(value), 10, 63); err == nil {
+				d.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+		// Other fields are ignored.
+	}
+}
+
+func (d *sseDecoder) nextSingle() (sseEvent, error) {
+	if d.r == nil {
+		return sseEvent{}, io.EOF
+	}
+
+	b, err := io.ReadAll(d.r)
+	d.r = nil
+	if err != nil {
+		return sseEvent{}, err
+	}
+
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return sseEvent{}, io.EOF
+	}
+
+	return sseEvent{Type: "message", Data: b}, nil
+}
+
+// readLine returns the next line of the stream without its line ending.
+func (d *sseDecoder) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		buf, err := d.r.Peek(max(d.r.Buffered(), 1))
+		if len(buf) == 0 {
+			return nil, err
+		}
+
+		if d.skipLF {
+			d.skipLF = false
+			if buf[0] == '\n' {
+				_, _ = d.r.Discard(1)
+				continue
+			}
+		}
+
+		i := bytes.IndexAny(buf, "\r\n")
+		if i == -1 {
+			line = append(line, buf...)
+			_, _ = d.r.Discard(len(buf))
+			continue
+		}
+
+		line = append(line, buf[:i]...)
+		d.skipLF = buf[i] == '\r'
+		_, _ = d.r.Discard(i + 1)
+		return line, nil
+	}
+}
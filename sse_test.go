@@ -0,0 +1,153 @@
+package gptscript
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func decodeAll(t *testing.T, r io.Reader) []sseEvent {
+	t.Helper()
+
+	var events []sseEvent
+	d := newSSEDecoder(r)
+	for {
+		event, err := d.next()
+		if err == io.EOF {
+			return events
+		}
+		require.NoError(t, err)
+
+		// The data is only valid until the next call to next, so copy it.
+		event.Data = []byte(string(event.Data))
+		events = append(events, event)
+	}
+}
+
+func TestSSEDecoder(t *testing.T) {
+	stream := ": keep-alive\n\n" +
+		"data: first\n\n" +
+		"event: update\nid: 1\ndata: line 1\ndata:line 2\n\n" +
+		"retry: 1500\ndata: {\"a\":1}\r\n\r\n" +
+		"id: 2\rdata: third\r\r" +
+		"data: incomplete"
+
+	expected := []sseEvent{
+		{Type: "message", Data: []byte("first")},
+		{ID: "1", Type: "update", Data: []byte("line 1\nline 2")},
+		{ID: "1", Type: "message", Data: []byte(`{"a":1}`)},
+		{ID: "2", Type: "message", Data: []byte("third")},
+	}
+
+	require.Equal(t, expected, decodeAll(t, strings.NewReader(stream)))
+	// Frames that are split across reads are decoded the same way.
+	require.Equal(t, expected, decodeAll(t, iotest.OneByteReader(strings.NewReader(stream))))
+
+	d := newSSEDecoder(strings.NewReader(stream))
+	for range expected {
+		_, err := d.next()
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1500*time.Millisecond, d.retry)
+	require.Equal(t, "2", d.lastID)
+}
+
+func TestSSEDecoderHugeFrame(t *testing.T) {
+	data := strings.Repeat("x", 10*1024*1024)
+	events := decodeAll(t, strings.NewReader("data: "+data+"\n\ndata: next\n\n"))
+
+	require.Len(t, events, 2)
+	require.Equal(t, data, string(events[0].Data))
+	require.Equal(t, "next", string(events[1].Data))
+}
+
+func TestRunHugeEvent(t *testing.T) {
+	request := map[string]any{"messages": []string{strings.Repeat("hello ", 200*1024)}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		b, _ := json.Marshal(Frame{Call: &CallFrame{CallContext: CallContext{ID: "call1"}, Type: EventTypeCallProgress, LLMRequest: request}})
+		// Write the frame in small pieces so that it spans many reads.
+		_, _ = fmt.Fprint(w, "data: ")
+		for i := 0; i < len(b); i += 1000 {
+			_, _ = w.Write(b[i:min(i+1000, len(b))])
+		}
+		_, _ = fmt.Fprint(w, "\n\ndata: {\"stdout\": \"done\"}\n\ndata: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	g, err := NewGPTScript(GlobalOptions{URL: srv.URL})
+	require.NoError(t, err)
+	defer g.Close()
+
+	run, err := g.Run(context.Background(), "test.gpt", Options{})
+	require.NoError(t, err)
+
+	out, err := run.Text()
+	require.NoError(t, err)
+	require.Equal(t, "done", out)
+	require.Len(t, run.Calls()["call1"].LLMRequest.(map[string]any)["messages"].([]any)[0], 6*200*1024)
+}
+
+func TestRunEventStreamWithoutContentType(t *testing.T) {
+	for _, contentType := range []string{"", "text/plain"} {
+		t.Run(fmt.Sprintf("%q", contentType), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if contentType == "" {
+					// Keep the server from detecting the content type.
+					w.Header()["Content-Type"] = nil
+				} else {
+					w.Header().Set("Content-Type", contentType)
+				}
+				_, _ = fmt.Fprint(w, "data: {\"call\": {\"id\": \"call1\", \"type\": \"callStart\"}}\n\ndata: {\"stdout\": \"done\"}\n\ndata: [DONE]\n\n")
+			}))
+			defer srv.Close()
+
+			g, err := NewGPTScript(GlobalOptions{URL: srv.URL})
+			require.NoError(t, err)
+			defer g.Close()
+
+			run, err := g.Run(context.Background(), "test.gpt", Options{})
+			require.NoError(t, err)
+
+			out, err := run.Text()
+			require.NoError(t, err)
+			require.Equal(t, "done", out)
+			require.Contains(t, run.Calls(), "call1")
+		})
+	}
+}
+
+func TestResponseDecoderSingleEvent(t *testing.T) {
+	for _, body := range []string{`{"stdout": "data: not an event"}`, "d", ""} {
+		d := newResponseDecoder(&http.Response{Body: io.NopCloser(strings.NewReader(body))})
+		require.True(t, d.single, body)
+	}
+}
+
+func TestRunMalformedEvent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "data: {\"stdout\": \"partial\"\n\ndata: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	g, err := NewGPTScript(GlobalOptions{URL: srv.URL})
+	require.NoError(t, err)
+	defer g.Close()
+
+	run, err := g.Run(context.Background(), "test.gpt", Options{})
+	require.NoError(t, err)
+
+	_, err = run.Text()
+	require.ErrorContains(t, err, "failed to decode event")
+	require.Equal(t, Error, run.State())
+}
@@ -0,0 +1,122 @@
+package fakeserver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gptscript-ai/go-gptscript"
+	"github.com/gptscript-ai/go-gptscript/pkg/gptscripttest"
+	"github.com/stretchr/testify/require"
+)
+
+func budgetCall(id, parentID string, category gptscript.ToolCategory, tokens int, output string) gptscript.Frame {
+	return gptscript.Frame{Call: &gptscript.CallFrame{
+		CallContext: gptscript.CallContext{ID: id, ParentID: parentID, ToolCategory: category},
+		Type:        gptscript.EventTypeCallProgress,
+		Usage:       gptscript.Usage{TotalTokens: tokens},
+		Output:      []gptscript.Output{{Content: output}},
+	}}
+}
+
+// budgetEvents are the events of a run that uses 2400 tokens and makes two tool calls.
+var budgetEvents = []gptscript.Frame{
+	{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}},
+	budgetCall("1", "", gptscript.NoCategory, 400, "thinking"),
+	budgetCall("2", "1", gptscript.NoCategory, 400, ""),
+	budgetCall("3", "1", gptscript.NoCategory, 400, ""),
+	// Calls to the model provider and credential tools are not tool calls of the budget.
+	budgetCall("4", "1", gptscript.ProviderToolCategory, 0, ""),
+	budgetCall("5", "1", gptscript.CredentialToolCategory, 0, ""),
+	budgetCall("1", "", gptscript.NoCategory, 1600, "still thinking"),
+	{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunFinish}},
+}
+
+func TestTokenBudget(t *testing.T) {
+	// The events are needed to count the tokens, so they are requested even though IncludeEvents isn't set.
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{Events: budgetEvents, Delay: 50 * time.Millisecond, Output: "done"})
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{Budget: gptscript.Budget{MaxTokens: 1000}})
+	require.NoError(t, err)
+
+	_, err = run.Text()
+
+	var budgetErr gptscript.ErrBudgetExceeded
+	require.ErrorAs(t, err, &budgetErr)
+	require.Equal(t, gptscript.BudgetLimitTokens, budgetErr.Limit)
+	require.Equal(t, "thinking", budgetErr.PartialOutput)
+	require.Equal(t, "run1", budgetErr.RunID)
+	require.ErrorContains(t, err, "budget exceeded: used 1200 tokens of 1000")
+	require.Len(t, s.RequestsFor("abort/run1"), 1)
+
+	var req gptscripttest.RunRequest
+	require.NoError(t, s.RequestsFor("run")[0].Decode(&req))
+	require.True(t, req.IncludeEvents)
+}
+
+func TestToolCallBudget(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{Events: budgetEvents, Delay: 50 * time.Millisecond, Output: "done"})
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{Budget: gptscript.Budget{MaxToolCalls: 1}})
+	require.NoError(t, err)
+
+	_, err = run.Text()
+
+	var budgetErr gptscript.ErrBudgetExceeded
+	require.ErrorAs(t, err, &budgetErr)
+	require.Equal(t, gptscript.BudgetLimitToolCalls, budgetErr.Limit)
+}
+
+func TestDurationBudget(t *testing.T) {
+	s, g := newTestClient(t)
+	s.HandleRun(func(_ gptscripttest.RunRequest, stream *gptscripttest.Stream) {
+		_ = stream.Send(budgetEvents[0])
+		_ = stream.Send(budgetEvents[1])
+		<-stream.Context().Done()
+		if errors.Is(context.Cause(stream.Context()), gptscripttest.ErrAborted) {
+			_ = stream.Stdout(gptscripttest.AbortedOutput)
+		}
+	})
+
+	// The run's events are needed for its ID and output, even though only its duration is limited.
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{Budget: gptscript.Budget{MaxDuration: 100 * time.Millisecond}})
+	require.NoError(t, err)
+
+	_, err = run.Text()
+
+	var budgetErr gptscript.ErrBudgetExceeded
+	require.ErrorAs(t, err, &budgetErr)
+	require.Equal(t, gptscript.BudgetLimitDuration, budgetErr.Limit)
+	require.Equal(t, "thinking", budgetErr.PartialOutput)
+	require.Len(t, s.RequestsFor("abort/run1"), 1)
+}
+
+func TestDurationBudgetBeforeRunStart(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{Events: budgetEvents[1:], Delay: 500 * time.Millisecond, Output: "done"})
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{Budget: gptscript.Budget{MaxDuration: 100 * time.Millisecond}})
+	require.NoError(t, err)
+
+	_, err = run.Text()
+
+	var budgetErr gptscript.ErrBudgetExceeded
+	require.ErrorAs(t, err, &budgetErr)
+	require.Equal(t, gptscript.BudgetLimitDuration, budgetErr.Limit)
+	// The server never sent the ID of the run, so it was canceled instead of aborted.
+	require.Empty(t, s.RequestsFor("abort/run1"))
+}
+
+func TestBudgetNotExceeded(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{Events: budgetEvents, Output: "done"})
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{Budget: gptscript.Budget{MaxTokens: 10000, MaxToolCalls: 2, MaxDuration: time.Minute}})
+	require.NoError(t, err)
+
+	_, err = run.Text()
+	require.NoError(t, err)
+}
@@ -0,0 +1,279 @@
+package fakeserver
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gptscript-ai/go-gptscript"
+	"github.com/gptscript-ai/go-gptscript/pkg/gptscripttest"
+	"github.com/stretchr/testify/require"
+)
+
+// chatServer responds to each turn of a chat with the input, which is also the chat state after the turn.
+func chatServer(t *testing.T) (*gptscripttest.Server, *gptscript.GPTScript) {
+	t.Helper()
+
+	s, g := newTestClient(t)
+	s.HandleRun(func(req gptscripttest.RunRequest, stream *gptscripttest.Stream) {
+		if req.Input == "fail" {
+			stream.Fail(500, "model unavailable")
+			return
+		}
+		_ = stream.Stdout(gptscripttest.ChatOutput{
+			Content: "you said " + req.Input,
+			Done:    req.Input == "bye",
+			State:   req.Input,
+		})
+	})
+
+	return s, g
+}
+
+func TestChatSession(t *testing.T) {
+	s, g := chatServer(t)
+	store := gptscript.NewMemoryChatSessionStore()
+
+	session, err := g.NewChatSession(context.Background(), store, gptscript.ChatSessionOptions{ID: "chat1", ToolPath: "chat.gpt"})
+	require.NoError(t, err)
+	require.Equal(t, "chat1", session.ID())
+
+	out, err := session.Send(context.Background(), "hello")
+	require.NoError(t, err)
+	require.Equal(t, "you said hello", out)
+
+	// A failed turn is saved, but doesn't change the chat state.
+	_, err = session.Send(context.Background(), "fail")
+	require.Error(t, err)
+	require.Equal(t, `"hello"`, session.ChatState())
+
+	// The session is resumed from the store, as another process would.
+	resumed, err := g.ResumeChatSession(context.Background(), store, "chat1", gptscript.Options{})
+	require.NoError(t, err)
+	require.Nil(t, resumed.LastRun())
+
+	out, err = resumed.Send(context.Background(), "bye")
+	require.NoError(t, err)
+	require.Equal(t, "you said bye", out)
+	require.Equal(t, `"bye"`, resumed.ChatState())
+	require.True(t, resumed.Done())
+
+	// The resumed session continued from the chat state of the last turn that didn't fail.
+	requests := s.RequestsFor("run")
+	var last gptscripttest.RunRequest
+	require.NoError(t, requests[len(requests)-1].Decode(&last))
+	require.Equal(t, `"hello"`, last.ChatState)
+
+	turns := resumed.Turns()
+	require.Len(t, turns, 3)
+	require.Equal(t, "hello", turns[0].Input)
+	require.Equal(t, "you said hello", turns[0].Output)
+	require.Equal(t, "fail", turns[1].Input)
+	require.NotEmpty(t, turns[1].Error)
+	require.Equal(t, "you said bye", turns[2].Output)
+
+	_, err = resumed.Send(context.Background(), "again")
+	require.ErrorContains(t, err, "chat session is done")
+
+	require.NoError(t, resumed.Delete(context.Background()))
+	_, err = g.ResumeChatSession(context.Background(), store, "chat1", gptscript.Options{})
+	require.ErrorIs(t, err, gptscript.ErrChatSessionNotFound)
+}
+
+func TestChatSessionEvents(t *testing.T) {
+	s, g := newTestClient(t)
+	release := make(chan struct{})
+	s.HandleRun(func(req gptscripttest.RunRequest, stream *gptscripttest.Stream) {
+		// There are more events than fit in the buffer of the events channel, which Send doesn't return.
+		_ = stream.Send(gptscript.Frame{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}})
+		for i := range 150 {
+			_ = stream.Send(gptscript.Frame{Call: &gptscript.CallFrame{CallContext: gptscript.CallContext{ID: fmt.Sprintf("call%d", i)}, Type: gptscript.EventTypeCallStart}})
+		}
+
+		<-release
+		_ = stream.Stdout(gptscripttest.ChatOutput{Content: "you said " + req.Input, State: req.Input})
+	})
+
+	session, err := g.NewChatSession(context.Background(), gptscript.NewMemoryChatSessionStore(), gptscript.ChatSessionOptions{
+		ToolPath: "chat.gpt",
+		Options:  gptscript.Options{IncludeEvents: true},
+	})
+	require.NoError(t, err)
+
+	sent := make(chan error, 1)
+	go func() {
+		_, err := session.Send(context.Background(), "hello")
+		sent <- err
+	}()
+
+	// The session can be read while the turn is sent.
+	read := make(chan []gptscript.ChatTurn, 1)
+	go func() {
+		read <- session.Turns()
+	}()
+	select {
+	case turns := <-read:
+		require.Empty(t, turns)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out reading the turns while a turn was sent")
+	}
+
+	close(release)
+	select {
+	case err := <-sent:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out sending the turn")
+	}
+	require.Equal(t, `"hello"`, session.ChatState())
+}
+
+func TestFileChatSessionStore(t *testing.T) {
+	_, g := chatServer(t)
+	store := gptscript.NewFileChatSessionStore(t.TempDir())
+
+	session, err := g.NewChatSession(context.Background(), store, gptscript.ChatSessionOptions{
+		Tools: []gptscript.ToolDef{{Instructions: "Chat with the user."}},
+	})
+	require.NoError(t, err)
+	require.Len(t, session.ID(), 32)
+
+	_, err = session.Send(context.Background(), "hello")
+	require.NoError(t, err)
+
+	record, err := store.Load(context.Background(), session.ID())
+	require.NoError(t, err)
+	require.Equal(t, `"hello"`, record.ChatState)
+	require.Equal(t, "Chat with the user.", record.Tools[0].Instructions)
+	require.Len(t, record.Turns, 1)
+
+	_, err = store.Load(context.Background(), "../escape")
+	require.ErrorContains(t, err, "invalid chat session ID")
+
+	require.NoError(t, store.Delete(context.Background(), session.ID()))
+	require.NoError(t, store.Delete(context.Background(), session.ID()))
+	_, err = store.Load(context.Background(), session.ID())
+	require.ErrorIs(t, err, gptscript.ErrChatSessionNotFound)
+}
+
+func TestWorkspaceChatSessionStore(t *testing.T) {
+	s, g := chatServer(t)
+
+	var (
+		lock  sync.Mutex
+		files = make(map[string]string)
+	)
+	s.Handle("workspaces/write-file", func(req gptscripttest.Request) (any, error) {
+		var body struct {
+			ID       string `json:"id"`
+			FilePath string `json:"filePath"`
+			Contents string `json:"contents"`
+		}
+		if err := req.Decode(&body); err != nil {
+			return nil, err
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		files[body.ID+"/"+body.FilePath] = body.Contents
+		return "", nil
+	})
+	s.Handle("workspaces/read-file", func(req gptscripttest.Request) (any, error) {
+		var body struct {
+			ID       string `json:"id"`
+			FilePath string `json:"filePath"`
+		}
+		if err := req.Decode(&body); err != nil {
+			return nil, err
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		contents, ok := files[body.ID+"/"+body.FilePath]
+		if !ok {
+			return nil, &gptscripttest.Error{StatusCode: 500, Message: "not found: " + body.ID + "/" + body.FilePath}
+		}
+		return contents, nil
+	})
+
+	store := gptscript.NewWorkspaceChatSessionStore(g, "ws1")
+	session, err := g.NewChatSession(context.Background(), store, gptscript.ChatSessionOptions{ID: "chat1", ToolPath: "chat.gpt"})
+	require.NoError(t, err)
+
+	_, err = session.Send(context.Background(), "hello")
+	require.NoError(t, err)
+	require.Contains(t, files, "ws1/chat-sessions/chat1.json")
+
+	contents, err := base64.StdEncoding.DecodeString(files["ws1/chat-sessions/chat1.json"])
+	require.NoError(t, err)
+	require.Contains(t, string(contents), `"input":"hello"`)
+
+	resumed, err := g.ResumeChatSession(context.Background(), store, "chat1", gptscript.Options{})
+	require.NoError(t, err)
+	require.Len(t, resumed.Turns(), 1)
+
+	_, err = g.ResumeChatSession(context.Background(), store, "chat2", gptscript.Options{})
+	require.ErrorIs(t, err, gptscript.ErrChatSessionNotFound)
+
+	// A new session can't replace the one that is already in the store.
+	_, err = g.NewChatSession(context.Background(), store, gptscript.ChatSessionOptions{ID: "chat1", ToolPath: "chat.gpt"})
+	require.ErrorIs(t, err, gptscript.ErrChatSessionExists)
+
+	resumed, err = g.ResumeChatSession(context.Background(), store, "chat1", gptscript.Options{})
+	require.NoError(t, err)
+	require.Len(t, resumed.Turns(), 1)
+}
+
+func TestChatSessionRewindAndBranch(t *testing.T) {
+	s, g := chatServer(t)
+	store := gptscript.NewMemoryChatSessionStore()
+
+	session, err := g.NewChatSession(context.Background(), store, gptscript.ChatSessionOptions{ID: "chat1", ToolPath: "chat.gpt"})
+	require.NoError(t, err)
+	for _, input := range []string{"one", "two", "fail", "three"} {
+		_, _ = session.Send(context.Background(), input)
+	}
+
+	_, err = session.Branch(context.Background(), 3, "chat1")
+	require.ErrorIs(t, err, gptscript.ErrChatSessionExists)
+
+	branch, err := session.Branch(context.Background(), 3, "chat2")
+	require.NoError(t, err)
+	require.Equal(t, "chat2", branch.ID())
+	require.Len(t, branch.Turns(), 3)
+	// The failed turn is kept, but the branch continues from the last turn that didn't fail.
+	require.Equal(t, `"two"`, branch.ChatState())
+
+	record, err := store.Load(context.Background(), "chat2")
+	require.NoError(t, err)
+	require.Equal(t, "chat1", record.ParentID)
+	require.Equal(t, 3, record.ParentTurns)
+
+	// Editing the second message rewinds to the first turn and sends the new input.
+	require.NoError(t, session.Rewind(context.Background(), 1))
+	require.Equal(t, `"one"`, session.ChatState())
+
+	out, err := session.Send(context.Background(), "two, edited")
+	require.NoError(t, err)
+	require.Equal(t, "you said two, edited", out)
+
+	requests := s.RequestsFor("run")
+	var last gptscripttest.RunRequest
+	require.NoError(t, requests[len(requests)-1].Decode(&last))
+	require.Equal(t, `"one"`, last.ChatState)
+
+	resumed, err := g.ResumeChatSession(context.Background(), store, "chat1", gptscript.Options{})
+	require.NoError(t, err)
+	require.Len(t, resumed.Turns(), 2)
+	require.Equal(t, "two, edited", resumed.Turns()[1].Input)
+
+	// The branch is not changed by the original session.
+	require.Len(t, branch.Turns(), 3)
+
+	require.NoError(t, session.Rewind(context.Background(), 0))
+	require.Empty(t, session.ChatState())
+	require.ErrorContains(t, session.Rewind(context.Background(), 1), "cannot rewind to turn 1: the session has 0 turns")
+}
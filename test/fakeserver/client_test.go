@@ -0,0 +1,260 @@
+// Package fakeserver tests the client against the fake SDK server in pkg/gptscripttest. Unlike the tests in the root
+// package, they don't need OPENAI_API_KEY or GPTSCRIPT_URL.
+package fakeserver
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gptscript-ai/go-gptscript"
+	"github.com/gptscript-ai/go-gptscript/pkg/gptscripttest"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient starts a fake SDK server and returns it with a client that uses it. Both are closed when the test ends.
+func newTestClient(t *testing.T, opts ...gptscript.GlobalOptions) (*gptscripttest.Server, *gptscript.GPTScript) {
+	t.Helper()
+
+	s := gptscripttest.NewServer()
+	t.Cleanup(s.Close)
+
+	g, err := gptscript.NewGPTScript(append(opts, gptscript.GlobalOptions{URL: s.URL})...)
+	require.NoError(t, err)
+	t.Cleanup(g.Close)
+
+	return s, g
+}
+
+type recordingTransport struct {
+	lock  sync.Mutex
+	paths []string
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.lock.Lock()
+	rt.paths = append(rt.paths, req.URL.Path)
+	rt.lock.Unlock()
+
+	req.Header.Set("X-Test", "true")
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestCustomHTTPTransport(t *testing.T) {
+	rt := new(recordingTransport)
+	s, g := newTestClient(t, gptscript.GlobalOptions{HTTPClient: &http.Client{}, HTTPTransport: rt})
+
+	s.Script(gptscripttest.Script{Output: "done"})
+	s.Respond("credentials", []gptscript.Credential{})
+	s.Respond("workspaces/list", []string{})
+	s.Respond("datasets", []gptscript.DatasetMeta{})
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{})
+	require.NoError(t, err)
+	_, err = run.Text()
+	require.NoError(t, err)
+
+	_, err = g.Version(context.Background())
+	require.NoError(t, err)
+	_, err = g.ListCredentials(context.Background(), gptscript.ListCredentialsOptions{})
+	require.NoError(t, err)
+	_, err = g.ListFilesInWorkspace(context.Background(), gptscript.ListFilesInWorkspaceOptions{WorkspaceID: "1"})
+	require.NoError(t, err)
+	_, err = g.ListDatasets(context.Background())
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"/run", "/version", "/credentials", "/workspaces/list", "/datasets"}, rt.paths)
+	for _, req := range s.Requests() {
+		require.Equal(t, "true", req.Header.Get("X-Test"))
+	}
+}
+
+func TestRunHTTPClientOverride(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{Output: "done"})
+
+	rt := new(recordingTransport)
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{GlobalOptions: gptscript.GlobalOptions{HTTPTransport: rt}})
+	require.NoError(t, err)
+	_, err = run.Text()
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"/run"}, rt.paths)
+}
+
+func TestUnixSocketURL(t *testing.T) {
+	s, err := gptscripttest.NewUnixServer(filepath.Join(t.TempDir(), "gptscript.sock"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	s.Script(gptscripttest.Script{Output: "done"})
+
+	g, err := gptscript.NewGPTScript(gptscript.GlobalOptions{URL: s.URL})
+	require.NoError(t, err)
+	defer g.Close()
+
+	require.Equal(t, s.URL, g.URL())
+
+	out, err := g.Version(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "gptscript version v0.0.0-gptscripttest", out)
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{})
+	require.NoError(t, err)
+
+	out, err = run.Text()
+	require.NoError(t, err)
+	require.Equal(t, "done", out)
+}
+
+// failFirst returns a handler that fails with the status code until it has been called the given number of times.
+func failFirst(failures, statusCode int, out any) gptscripttest.HandlerFunc {
+	var calls int
+	return func(gptscripttest.Request) (any, error) {
+		calls++
+		if calls <= failures {
+			return nil, &gptscripttest.Error{StatusCode: statusCode, Message: "unavailable"}
+		}
+		return out, nil
+	}
+}
+
+var retryOptions = gptscript.GlobalOptions{RetryPolicy: &gptscript.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}}
+
+func TestRetryIdempotentCommand(t *testing.T) {
+	s, g := newTestClient(t, retryOptions)
+	s.Handle("workspaces/list", failFirst(2, http.StatusServiceUnavailable, []string{"file1"}))
+
+	files, err := g.ListFilesInWorkspace(context.Background(), gptscript.ListFilesInWorkspaceOptions{WorkspaceID: "1"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"file1"}, files)
+	require.Len(t, s.RequestsFor("workspaces/list"), 3)
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	s, g := newTestClient(t, retryOptions)
+	s.Handle("version", failFirst(3, http.StatusBadGateway, "gptscript version"))
+
+	_, err := g.Version(context.Background())
+	require.Error(t, err)
+	require.Len(t, s.RequestsFor("version"), 3)
+}
+
+func TestNoRetryForNonRetryableStatus(t *testing.T) {
+	s, g := newTestClient(t, retryOptions)
+	s.Handle("version", failFirst(1, http.StatusInternalServerError, "gptscript version"))
+
+	_, err := g.Version(context.Background())
+	require.Error(t, err)
+	require.Len(t, s.RequestsFor("version"), 1)
+}
+
+func TestNoRetryForNonIdempotentCommand(t *testing.T) {
+	s, g := newTestClient(t, retryOptions)
+	s.Handle("credentials/create", failFirst(1, http.StatusServiceUnavailable, ""))
+	s.Handle("workspaces/write-file", failFirst(1, http.StatusServiceUnavailable, ""))
+
+	require.Error(t, g.CreateCredential(context.Background(), gptscript.Credential{ToolName: "test"}))
+	require.Len(t, s.RequestsFor("credentials/create"), 1)
+
+	require.Error(t, g.WriteFileInWorkspace(context.Background(), "file", []byte("contents"), gptscript.WriteFileInWorkspaceOptions{WorkspaceID: "1"}))
+	require.Len(t, s.RequestsFor("workspaces/write-file"), 1)
+}
+
+func TestRetryWriteWithRevisionPrecondition(t *testing.T) {
+	s, g := newTestClient(t, retryOptions)
+	s.Handle("workspaces/write-file", failFirst(1, http.StatusServiceUnavailable, ""))
+
+	require.NoError(t, g.WriteFileInWorkspace(context.Background(), "file", []byte("contents"), gptscript.WriteFileInWorkspaceOptions{WorkspaceID: "1", LatestRevisionID: "2"}))
+	require.Len(t, s.RequestsFor("workspaces/write-file"), 2)
+}
+
+func TestRetryConnectionRefused(t *testing.T) {
+	s := gptscripttest.NewServer()
+	url := s.URL
+	s.Close()
+
+	g, err := gptscript.NewGPTScript(gptscript.GlobalOptions{
+		URL:         url,
+		RetryPolicy: &gptscript.RetryPolicy{MaxAttempts: 3, InitialBackoff: 50 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	defer g.Close()
+
+	start := time.Now()
+	_, err = g.Version(context.Background())
+	require.Error(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestResumeEventStream(t *testing.T) {
+	s, g := newTestClient(t)
+	s.EnableResume()
+	s.HandleRun(func(_ gptscripttest.RunRequest, stream *gptscripttest.Stream) {
+		_ = stream.Send(gptscript.Frame{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}})
+		_ = stream.Send(gptscript.Frame{Call: &gptscript.CallFrame{CallContext: gptscript.CallContext{ID: "call1"}, Type: gptscript.EventTypeCallStart}})
+		// The handler runs on the server's goroutine, where the test can't be stopped with require.
+		if err := stream.Disconnect(); err != nil {
+			t.Errorf("failed to disconnect: %v", err)
+		}
+		_ = stream.Send(gptscript.Frame{Call: &gptscript.CallFrame{CallContext: gptscript.CallContext{ID: "call1"}, Type: gptscript.EventTypeCallFinish}})
+		_ = stream.Stdout("done")
+	})
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{IncludeEvents: true, ResumeStreams: true})
+	require.NoError(t, err)
+
+	var types []gptscript.EventType
+	for e := range run.Events() {
+		if e.Run != nil {
+			types = append(types, e.Run.Type)
+		} else if e.Call != nil {
+			types = append(types, e.Call.Type)
+		}
+	}
+
+	out, err := run.Text()
+	require.NoError(t, err)
+	require.Equal(t, "done", out)
+	require.Equal(t, []gptscript.EventType{gptscript.EventTypeRunStart, gptscript.EventTypeCallStart, gptscript.EventTypeRunReconnected, gptscript.EventTypeCallFinish}, types)
+
+	resumes := s.RequestsFor("resume/run1")
+	require.Len(t, resumes, 1)
+	require.Equal(t, "2", resumes[0].Header.Get("Last-Event-ID"))
+}
+
+func TestLostEventStreamNotResumed(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		enableResume  bool
+		resumeStreams bool
+	}{
+		// Like the real server, the fake doesn't identify its events unless resuming is enabled.
+		{name: "server", resumeStreams: true},
+		{name: "client", enableResume: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, g := newTestClient(t)
+			if tt.enableResume {
+				s.EnableResume()
+			}
+			s.HandleRun(func(_ gptscripttest.RunRequest, stream *gptscripttest.Stream) {
+				_ = stream.Send(gptscript.Frame{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}})
+				if err := stream.Disconnect(); err != nil {
+					t.Errorf("failed to disconnect: %v", err)
+				}
+				_ = stream.Stdout("done")
+			})
+
+			run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{StreamText: true, ResumeStreams: tt.resumeStreams})
+			require.NoError(t, err)
+
+			_, err = run.Text()
+			require.ErrorAs(t, err, &gptscript.ErrServerUnavailable{})
+			require.Empty(t, s.RequestsFor("resume/run1"))
+		})
+	}
+}
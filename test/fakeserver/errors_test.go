@@ -0,0 +1,275 @@
+package fakeserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gptscript-ai/go-gptscript"
+	"github.com/gptscript-ai/go-gptscript/pkg/gptscripttest"
+	"github.com/stretchr/testify/require"
+)
+
+func failedRunScript(calls ...gptscript.CallFrame) gptscripttest.Script {
+	events := []gptscript.Frame{{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}}}
+	for _, call := range calls {
+		events = append(events, gptscript.Frame{Call: &call})
+	}
+	events = append(events, gptscript.Frame{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunFinish, Error: "something failed"}})
+
+	return gptscripttest.Script{Events: events, Stderr: "something failed"}
+}
+
+// modelCall is a call to a tool that uses the model, which has sent a request to the model that wasn't answered yet.
+var modelCall = gptscript.CallFrame{
+	CallContext: gptscript.CallContext{ID: "call1", Tool: gptscript.Tool{ToolDef: gptscript.ToolDef{Instructions: "Say hello"}}},
+	Type:        gptscript.EventTypeChat,
+	LLMRequest:  map[string]any{"model": "gpt-4o"},
+}
+
+func TestStatusCodeErrors(t *testing.T) {
+	for _, tt := range []struct {
+		statusCode int
+		target     any
+	}{
+		{http.StatusUnauthorized, &gptscript.ErrUnauthorized{}},
+		{http.StatusForbidden, &gptscript.ErrUnauthorized{}},
+		{http.StatusBadRequest, &gptscript.ErrBadRequest{}},
+		{http.StatusNotFound, &gptscript.ErrNotFound{}},
+		{http.StatusServiceUnavailable, &gptscript.ErrServerUnavailable{}},
+	} {
+		t.Run(http.StatusText(tt.statusCode), func(t *testing.T) {
+			s, g := newTestClient(t)
+			s.Script(gptscripttest.Script{StatusCode: tt.statusCode, Stderr: "failed"})
+
+			run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{IncludeEvents: true})
+			require.NoError(t, err)
+
+			_, err = run.Text()
+			require.ErrorAs(t, err, tt.target)
+
+			var runErr gptscript.RunError
+			require.ErrorAs(t, err, &runErr)
+			require.Equal(t, tt.statusCode, runErr.StatusCode)
+			require.Equal(t, "failed", runErr.Output)
+		})
+	}
+}
+
+func TestModelProviderError(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(failedRunScript(modelCall, gptscript.CallFrame{
+		CallContext: gptscript.CallContext{ID: "call2", ParentID: "call1", ToolCategory: gptscript.ProviderToolCategory},
+		Type:        gptscript.EventTypeCallStart,
+	}))
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{IncludeEvents: true})
+	require.NoError(t, err)
+
+	_, err = run.Text()
+
+	var providerErr gptscript.ErrModelProvider
+	require.ErrorAs(t, err, &providerErr)
+	require.Equal(t, "run1", providerErr.RunID)
+	require.Equal(t, "call2", providerErr.CallID)
+	require.Equal(t, "something failed", providerErr.Output)
+	require.Equal(t, "run encountered an error: something failed with error output: something failed", err.Error())
+}
+
+func TestModelCallError(t *testing.T) {
+	// The model call has no calls of its own and its request wasn't answered, so the request to the model failed.
+	s, g := newTestClient(t)
+	s.Script(failedRunScript(modelCall))
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{IncludeEvents: true})
+	require.NoError(t, err)
+
+	_, err = run.Text()
+
+	var providerErr gptscript.ErrModelProvider
+	require.ErrorAs(t, err, &providerErr)
+	require.Equal(t, "call1", providerErr.CallID)
+	require.False(t, errors.As(err, &gptscript.ErrToolExecution{}))
+}
+
+func TestToolExecutionError(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(failedRunScript(gptscript.CallFrame{
+		CallContext: gptscript.CallContext{ID: "call1", Tool: gptscript.Tool{ToolDef: gptscript.ToolDef{Instructions: "#!/bin/bash\nexit 1"}}},
+		Type:        gptscript.EventTypeChat,
+	}))
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{IncludeEvents: true})
+	require.NoError(t, err)
+
+	_, err = run.Text()
+
+	var toolErr gptscript.ErrToolExecution
+	require.ErrorAs(t, err, &toolErr)
+	require.Equal(t, "run1", toolErr.RunID)
+	require.Equal(t, "call1", toolErr.CallID)
+	require.False(t, errors.As(err, &gptscript.ErrModelProvider{}))
+}
+
+func TestToolExecutionErrorUnderModelCall(t *testing.T) {
+	// The tool called by the model fails, and the model's call has an event after the tool's last one.
+	s, g := newTestClient(t)
+	s.Script(failedRunScript(
+		modelCall,
+		gptscript.CallFrame{
+			CallContext: gptscript.CallContext{ID: "call2", ParentID: "call1", Tool: gptscript.Tool{ToolDef: gptscript.ToolDef{Instructions: "#!/bin/bash\nexit 1"}}},
+			Type:        gptscript.EventTypeCallStart,
+		},
+		gptscript.CallFrame{CallContext: modelCall.CallContext, Type: gptscript.EventTypeCallSubCalls},
+	))
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{IncludeEvents: true})
+	require.NoError(t, err)
+
+	_, err = run.Text()
+
+	var toolErr gptscript.ErrToolExecution
+	require.ErrorAs(t, err, &toolErr)
+	require.Equal(t, "call2", toolErr.CallID)
+	require.False(t, errors.As(err, &gptscript.ErrModelProvider{}))
+}
+
+func TestNonCommandToolError(t *testing.T) {
+	// A context tool without a command that wasn't waiting for the model failed, so the cause isn't known.
+	s, g := newTestClient(t)
+	s.Script(failedRunScript(gptscript.CallFrame{
+		CallContext: gptscript.CallContext{ID: "call1", ToolCategory: gptscript.ContextToolCategory},
+		Type:        gptscript.EventTypeCallProgress,
+	}))
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{IncludeEvents: true})
+	require.NoError(t, err)
+
+	_, err = run.Text()
+
+	var runErr gptscript.RunError
+	require.ErrorAs(t, err, &runErr)
+	require.Equal(t, "call1", runErr.CallID)
+	require.False(t, errors.As(err, &gptscript.ErrToolExecution{}))
+	require.False(t, errors.As(err, &gptscript.ErrModelProvider{}))
+}
+
+func TestUnknownFailedCall(t *testing.T) {
+	// Two tools called in parallel didn't finish, so it isn't known which one failed.
+	s, g := newTestClient(t)
+	s.Script(failedRunScript(
+		modelCall,
+		gptscript.CallFrame{CallContext: gptscript.CallContext{ID: "call2", ParentID: "call1"}, Type: gptscript.EventTypeCallStart},
+		gptscript.CallFrame{CallContext: gptscript.CallContext{ID: "call3", ParentID: "call1"}, Type: gptscript.EventTypeCallStart},
+	))
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{IncludeEvents: true})
+	require.NoError(t, err)
+
+	_, err = run.Text()
+
+	var runErr gptscript.RunError
+	require.ErrorAs(t, err, &runErr)
+	require.Empty(t, runErr.CallID)
+	require.False(t, errors.As(err, &gptscript.ErrToolExecution{}))
+	require.False(t, errors.As(err, &gptscript.ErrModelProvider{}))
+}
+
+func TestAbortedError(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{
+		Events: []gptscript.Frame{
+			{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}},
+			{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunFinish}},
+		},
+		Delay: time.Second,
+	})
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{IncludeEvents: true})
+	require.NoError(t, err)
+
+	<-run.Events()
+	require.NoError(t, run.Close())
+	require.ErrorAs(t, run.Err(), &gptscript.ErrAborted{})
+}
+
+func TestServerUnavailableError(t *testing.T) {
+	s := gptscripttest.NewServer()
+	s.Close()
+
+	g, err := gptscript.NewGPTScript(gptscript.GlobalOptions{URL: s.URL})
+	require.NoError(t, err)
+	defer g.Close()
+
+	_, err = g.Run(context.Background(), "test.gpt", gptscript.Options{})
+	require.ErrorAs(t, err, &gptscript.ErrServerUnavailable{})
+
+	_, err = g.Version(context.Background())
+	require.ErrorAs(t, err, &gptscript.ErrServerUnavailable{})
+}
+
+func TestStructuredWorkspaceErrors(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Handle("workspaces/read-file", func(gptscripttest.Request) (any, error) {
+		return nil, &gptscripttest.Error{StatusCode: http.StatusInternalServerError, Message: "file is gone", Details: map[string]any{
+			"code":        "notFound",
+			"workspaceID": "ws1",
+			"filePath":    "test.txt",
+		}}
+	})
+	s.Handle("workspaces/write-file", func(gptscripttest.Request) (any, error) {
+		return nil, &gptscripttest.Error{StatusCode: http.StatusInternalServerError, Message: "revision mismatch", Details: map[string]any{
+			"code":              "conflict",
+			"workspaceID":       "ws1",
+			"filePath":          "test.txt",
+			"latestRevisionID":  "1",
+			"currentRevisionID": "2",
+		}}
+	})
+
+	_, err := g.ReadFileInWorkspace(context.Background(), "test.txt", gptscript.ReadFileInWorkspaceOptions{WorkspaceID: "ws1"})
+	var notFoundErr *gptscript.NotFoundInWorkspaceError
+	require.ErrorAs(t, err, &notFoundErr)
+	require.Equal(t, "ws1", notFoundErr.ID)
+	require.Equal(t, "test.txt", notFoundErr.Name)
+	require.ErrorIs(t, err, gptscript.ErrWorkspaceFileNotFound)
+	require.NotErrorIs(t, err, gptscript.ErrWorkspaceConflict)
+	require.Equal(t, "not found: ws1/test.txt", err.Error())
+
+	var runErr gptscript.RunError
+	require.ErrorAs(t, err, &runErr)
+	require.Equal(t, "file is gone", runErr.Output)
+
+	err = g.WriteFileInWorkspace(context.Background(), "test.txt", []byte("test"), gptscript.WriteFileInWorkspaceOptions{WorkspaceID: "ws1"})
+	var conflictErr *gptscript.ConflictInWorkspaceError
+	require.ErrorAs(t, err, &conflictErr)
+	require.Equal(t, "ws1", conflictErr.ID)
+	require.Equal(t, "test.txt", conflictErr.Name)
+	require.Equal(t, "1", conflictErr.LatestRevision)
+	require.Equal(t, "2", conflictErr.CurrentRevision)
+	require.ErrorIs(t, err, gptscript.ErrWorkspaceConflict)
+	require.ErrorAs(t, err, &runErr)
+	require.Equal(t, "revision mismatch", runErr.Output)
+	require.NotErrorIs(t, err, gptscript.ErrWorkspaceFileNotFound)
+}
+
+func TestLegacyWorkspaceErrors(t *testing.T) {
+	s, g := newTestClient(t)
+	s.RespondError("workspaces/stat-file", http.StatusInternalServerError, "failed to stat file: not found: ws1/test.txt")
+	s.RespondError("workspaces/write-file", http.StatusInternalServerError, "failed to write file: 500 Internal Server Error: conflict: ws1/test.txt (latest revision: 1, current revision: 2)")
+
+	_, err := g.StatFileInWorkspace(context.Background(), "test.txt", gptscript.StatFileInWorkspaceOptions{WorkspaceID: "ws1"})
+	var notFoundErr *gptscript.NotFoundInWorkspaceError
+	require.ErrorAs(t, err, &notFoundErr)
+
+	err = g.WriteFileInWorkspace(context.Background(), "test.txt", []byte("test"), gptscript.WriteFileInWorkspaceOptions{WorkspaceID: "ws1"})
+	var conflictErr *gptscript.ConflictInWorkspaceError
+	require.ErrorAs(t, err, &conflictErr)
+	require.Equal(t, "ws1", conflictErr.ID)
+	require.Equal(t, "test.txt", conflictErr.Name)
+	require.Equal(t, "1", conflictErr.LatestRevision)
+	require.Equal(t, "2", conflictErr.CurrentRevision)
+	require.ErrorAs(t, err, &gptscript.RunError{})
+}
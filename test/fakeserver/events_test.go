@@ -0,0 +1,243 @@
+package fakeserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gptscript-ai/go-gptscript"
+	"github.com/gptscript-ai/go-gptscript/pkg/gptscripttest"
+	"github.com/stretchr/testify/require"
+)
+
+// callEvents returns a run start event followed by the given number of call events.
+func callEvents(calls int) []gptscript.Frame {
+	events := []gptscript.Frame{{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}}}
+	for i := range calls {
+		events = append(events, gptscript.Frame{Call: &gptscript.CallFrame{CallContext: gptscript.CallContext{ID: fmt.Sprintf("call%d", i)}, Type: gptscript.EventTypeCallStart}})
+	}
+	return events
+}
+
+func callIDs(events <-chan gptscript.Frame) []string {
+	var ids []string
+	for e := range events {
+		if e.Call != nil {
+			ids = append(ids, e.Call.ID)
+		}
+	}
+	return ids
+}
+
+func TestEventDeliveryDropOldest(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{Events: callEvents(10), Output: "done"})
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{IncludeEvents: true, EventDelivery: gptscript.EventDeliveryDropOldest, EventBufferSize: 2})
+	require.NoError(t, err)
+
+	// The output is available without receiving any events.
+	out, err := run.Text()
+	require.NoError(t, err)
+	require.Equal(t, "done", out)
+
+	require.Equal(t, []string{"call8", "call9"}, callIDs(run.Events()))
+	require.Equal(t, 9, run.DroppedEvents())
+}
+
+func TestEventDeliveryUnbounded(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{Events: callEvents(10), Output: "done"})
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{IncludeEvents: true, EventDelivery: gptscript.EventDeliveryUnbounded, EventBufferSize: 2})
+	require.NoError(t, err)
+
+	out, err := run.Text()
+	require.NoError(t, err)
+	require.Equal(t, "done", out)
+
+	require.Len(t, callIDs(run.Events()), 10)
+	require.Zero(t, run.DroppedEvents())
+}
+
+func TestEventDeliveryBlockClose(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{Events: callEvents(10), Output: "done"})
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{IncludeEvents: true, EventBufferSize: 1})
+	require.NoError(t, err)
+
+	// Closing the run doesn't wait for the events to be received.
+	closed := make(chan error)
+	go func() {
+		closed <- run.Close()
+	}()
+
+	select {
+	case err := <-closed:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out closing the run")
+	}
+}
+
+func TestOnEvent(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{Events: callEvents(10), Output: "done"})
+
+	var ids []string
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{OnEvent: func(e gptscript.Frame) {
+		if e.Call != nil {
+			ids = append(ids, e.Call.ID)
+		}
+	}})
+	require.NoError(t, err)
+
+	out, err := run.Text()
+	require.NoError(t, err)
+	require.Equal(t, "done", out)
+	require.Len(t, ids, 10)
+	require.Empty(t, callIDs(run.Events()))
+}
+
+func TestStopFromOnEvent(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{
+		Events: []gptscript.Frame{
+			{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}},
+			{Call: &gptscript.CallFrame{CallContext: gptscript.CallContext{ID: "call1"}, Type: gptscript.EventTypeCallStart}},
+			{Call: &gptscript.CallFrame{CallContext: gptscript.CallContext{ID: "call1"}, Type: gptscript.EventTypeCallFinish}},
+		},
+		Delay:  100 * time.Millisecond,
+		Output: "done",
+	})
+
+	var (
+		runs    = make(chan *gptscript.Run, 1)
+		stopped = make(chan error, 1)
+	)
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{OnEvent: func(e gptscript.Frame) {
+		if e.Call != nil && e.Call.Type == gptscript.EventTypeCallStart {
+			stopped <- (<-runs).Stop()
+		}
+	}})
+	require.NoError(t, err)
+	runs <- run
+
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out stopping the run from OnEvent")
+	}
+
+	_, err = run.Text()
+	require.ErrorAs(t, err, &gptscript.ErrAborted{})
+}
+
+func TestCloseWhileHandlerBlocked(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{Events: callEvents(2), Output: "done"})
+
+	var (
+		handling = make(chan struct{})
+		unblock  = make(chan struct{})
+	)
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{OnEvent: func(e gptscript.Frame) {
+		if e.Call != nil && e.Call.ID == "call0" {
+			close(handling)
+			<-unblock
+		}
+	}})
+	require.NoError(t, err)
+	<-handling
+
+	// Close is called from another goroutine, so it waits for the run to stop, which is after the handler returns.
+	closed := make(chan error, 1)
+	go func() {
+		closed <- run.Close()
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("Close returned while the handler was still running")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(unblock)
+	select {
+	case err := <-closed:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out closing the run")
+	}
+	require.Equal(t, gptscript.Error, run.State())
+	require.ErrorAs(t, run.Err(), &gptscript.ErrAborted{})
+}
+
+func TestSubscriptions(t *testing.T) {
+	call := func(id string, category gptscript.ToolCategory, eventType gptscript.EventType) gptscript.Frame {
+		return gptscript.Frame{Call: &gptscript.CallFrame{CallContext: gptscript.CallContext{ID: id, ToolCategory: category}, Type: eventType}}
+	}
+
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{
+		Events: []gptscript.Frame{
+			{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}},
+			call("call1", gptscript.NoCategory, gptscript.EventTypeCallStart),
+			call("call2", gptscript.CredentialToolCategory, gptscript.EventTypeCallStart),
+			call("call2", gptscript.CredentialToolCategory, gptscript.EventTypeCallProgress),
+			call("call3", gptscript.ProviderToolCategory, gptscript.EventTypeCallProgress),
+			call("call1", gptscript.NoCategory, gptscript.EventTypeCallProgress),
+			{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunFinish}},
+		},
+		Output: "done",
+	})
+
+	var progress, all []string
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{
+		Subscriptions: []gptscript.Subscription{
+			{
+				Filter: gptscript.EventFilter{
+					Types:                 []gptscript.EventType{gptscript.EventTypeCallProgress},
+					ExcludeToolCategories: []gptscript.ToolCategory{gptscript.CredentialToolCategory, gptscript.ProviderToolCategory},
+				},
+				Handler: func(e gptscript.Frame) {
+					progress = append(progress, e.Call.ID)
+				},
+			},
+			{
+				Handler: func(e gptscript.Frame) {
+					all = append(all, string(e.Type()))
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	out, err := run.Text()
+	require.NoError(t, err)
+	require.Equal(t, "done", out)
+
+	require.Equal(t, []string{"call1"}, progress)
+	require.Equal(t, []string{"runStart", "callStart", "callStart", "callProgress", "callProgress", "callProgress", "runFinish"}, all)
+	// The events aren't sent on the channel, because only the subscriptions include them.
+	require.Empty(t, callIDs(run.Events()))
+
+	var req gptscripttest.RunRequest
+	require.NoError(t, s.RequestsFor("run")[0].Decode(&req))
+	require.True(t, req.IncludeEvents)
+}
+
+func TestEventFilter(t *testing.T) {
+	credentialCall := gptscript.Frame{Call: &gptscript.CallFrame{CallContext: gptscript.CallContext{ToolCategory: gptscript.CredentialToolCategory}, Type: gptscript.EventTypeCallStart}}
+	runStart := gptscript.Frame{Run: &gptscript.RunFrame{Type: gptscript.EventTypeRunStart}}
+
+	require.True(t, gptscript.EventFilter{}.Matches(credentialCall))
+	require.True(t, gptscript.EventFilter{ToolCategories: []gptscript.ToolCategory{gptscript.CredentialToolCategory}}.Matches(credentialCall))
+	require.False(t, gptscript.EventFilter{ToolCategories: []gptscript.ToolCategory{gptscript.NoCategory}}.Matches(credentialCall))
+	require.False(t, gptscript.EventFilter{ExcludeToolCategories: []gptscript.ToolCategory{gptscript.CredentialToolCategory}}.Matches(credentialCall))
+	require.True(t, gptscript.EventFilter{ExcludeToolCategories: []gptscript.ToolCategory{gptscript.CredentialToolCategory}}.Matches(runStart))
+	require.False(t, gptscript.EventFilter{Types: []gptscript.EventType{gptscript.EventTypeCallStart}}.Matches(runStart))
+}
@@ -0,0 +1,138 @@
+package fakeserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gptscript-ai/go-gptscript"
+	"github.com/gptscript-ai/go-gptscript/pkg/gptscripttest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{
+		Events: []gptscript.Frame{
+			{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}},
+			{Call: &gptscript.CallFrame{
+				CallContext: gptscript.CallContext{ID: "1", ToolName: "agent"},
+				Type:        gptscript.EventTypeCallProgress,
+				Output:      []gptscript.Output{{Content: "line 1\nline 2"}},
+			}},
+			{Call: &gptscript.CallFrame{
+				CallContext: gptscript.CallContext{ID: "1", ToolName: "agent"},
+				Type:        gptscript.EventTypeCallFinish,
+				Usage:       gptscript.Usage{TotalTokens: 42},
+			}},
+			{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunFinish}},
+		},
+		Output: gptscripttest.ChatOutput{Content: "Hello!", State: map[string]any{"step": 1.0}},
+	})
+
+	var recording bytes.Buffer
+	recorded, err := g.Run(context.Background(), "test.gpt", gptscript.Options{
+		IncludeEvents: true,
+		NewRecording:  func() io.Writer { return &recording },
+	})
+	require.NoError(t, err)
+
+	var recordedEvents []gptscript.Frame
+	for event := range recorded.Events() {
+		recordedEvents = append(recordedEvents, event)
+	}
+	_, err = recorded.Text()
+	require.NoError(t, err)
+
+	var onEvent []gptscript.Frame
+	replayed, err := gptscript.Replay(context.Background(), bytes.NewReader(recording.Bytes()), gptscript.Options{
+		OnEvent: func(f gptscript.Frame) { onEvent = append(onEvent, f) },
+	})
+	require.NoError(t, err)
+
+	out, err := replayed.Text()
+	require.NoError(t, err)
+	require.Equal(t, "Hello!", out)
+	require.Equal(t, recorded.ChatState(), replayed.ChatState())
+	require.Equal(t, recorded.State(), replayed.State())
+	require.Equal(t, recorded.Calls(), replayed.Calls())
+	require.Equal(t, 42, replayed.Usage().TotalTokens)
+	require.Equal(t, recordedEvents, onEvent)
+
+	// A replayed run can't be continued, because there is no server.
+	next, err := replayed.NextChat(context.Background(), "again")
+	require.ErrorContains(t, err, "a replayed run can't make requests to the SDK server")
+	require.Equal(t, gptscript.Error, next.State())
+}
+
+func TestRecordChat(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(
+		gptscripttest.Script{Output: gptscripttest.ChatOutput{Content: "Hi!", State: "state1"}},
+		gptscripttest.Script{Output: gptscripttest.ChatOutput{Content: "Bye!", State: "state2", Done: true}},
+	)
+
+	// Each run of the chat is recorded to its own writer.
+	var recordings []*bytes.Buffer
+	newRecording := func() io.Writer {
+		recordings = append(recordings, new(bytes.Buffer))
+		return recordings[len(recordings)-1]
+	}
+
+	run, err := g.Run(context.Background(), "chat.gpt", gptscript.Options{NewRecording: newRecording})
+	require.NoError(t, err)
+	_, err = run.Text()
+	require.NoError(t, err)
+
+	run, err = run.NextChat(context.Background(), "bye")
+	require.NoError(t, err)
+	_, err = run.Text()
+	require.NoError(t, err)
+
+	require.Len(t, recordings, 2)
+	for i, want := range []string{"Hi!", "Bye!"} {
+		replayed, err := gptscript.Replay(context.Background(), bytes.NewReader(recordings[i].Bytes()), gptscript.Options{})
+		require.NoError(t, err)
+
+		out, err := replayed.Text()
+		require.NoError(t, err)
+		require.Equal(t, want, out)
+		require.Equal(t, fmt.Sprintf(`"state%d"`, i+1), replayed.ChatState())
+	}
+
+	// Recordings of several runs written to the same writer are not merged.
+	_, err = gptscript.Replay(context.Background(), io.MultiReader(bytes.NewReader(recordings[0].Bytes()), bytes.NewReader(recordings[1].Bytes())), gptscript.Options{})
+	require.ErrorContains(t, err, "starts another run, but a recording can only have one")
+}
+
+func TestReplayError(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{StatusCode: http.StatusUnauthorized, Stderr: "invalid token"})
+
+	var recording bytes.Buffer
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{NewRecording: func() io.Writer { return &recording }})
+	require.NoError(t, err)
+	_, err = run.Text()
+	require.Error(t, err)
+
+	replayed, err := gptscript.Replay(context.Background(), &recording, gptscript.Options{})
+	require.NoError(t, err)
+
+	_, err = replayed.Text()
+	var unauthorized gptscript.ErrUnauthorized
+	require.ErrorAs(t, err, &unauthorized)
+	require.Equal(t, http.StatusUnauthorized, unauthorized.StatusCode)
+	require.Equal(t, "invalid token", replayed.ErrorOutput())
+}
+
+func TestReplayInvalidRecording(t *testing.T) {
+	_, err := gptscript.Replay(context.Background(), strings.NewReader(""), gptscript.Options{})
+	require.ErrorContains(t, err, "recording is empty")
+
+	_, err = gptscript.Replay(context.Background(), strings.NewReader(`{"version":2}`), gptscript.Options{})
+	require.ErrorContains(t, err, "unsupported recording version 2")
+}
@@ -0,0 +1,114 @@
+package fakeserver
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/gptscript-ai/go-gptscript"
+	"github.com/gptscript-ai/go-gptscript/pkg/gptscripttest"
+	"github.com/stretchr/testify/require"
+)
+
+func progress(id, parentID string, outputs ...string) gptscript.Frame {
+	call := &gptscript.CallFrame{CallContext: gptscript.CallContext{ID: id, ParentID: parentID}, Type: gptscript.EventTypeCallProgress}
+	for _, content := range outputs {
+		call.Output = append(call.Output, gptscript.Output{Content: content})
+	}
+	return gptscript.Frame{Call: call}
+}
+
+func TestTextStream(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{
+		Events: []gptscript.Frame{
+			{Run: &gptscript.RunFrame{ID: "1", Type: gptscript.EventTypeRunStart}},
+			progress("call1", "", "Wash"),
+			progress("call1", "", "Washington"),
+			// The text of the calls made by the entry call is not streamed.
+			progress("call2", "call1", "looking it up"),
+			progress("call1", "", "Washington", ", D.C."),
+			{Run: &gptscript.RunFrame{ID: "1", Type: gptscript.EventTypeRunFinish}},
+		},
+		Output: "Washington, D.C.",
+	})
+
+	run, err := g.Evaluate(context.Background(), gptscript.Options{StreamText: true}, gptscript.ToolDef{Instructions: "What is the capital of the united states?"})
+	require.NoError(t, err)
+
+	text, err := io.ReadAll(run.TextStream())
+	require.NoError(t, err)
+	require.Equal(t, "Washington, D.C.", string(text))
+
+	// A reader created after the run finished reads all the text.
+	b, err := io.ReadAll(run.TextStream())
+	require.NoError(t, err)
+	require.Equal(t, "Washington, D.C.", string(b))
+}
+
+func TestTextStreamManyEvents(t *testing.T) {
+	// There are more events than fit in the buffer of the events channel, which is never read.
+	events := []gptscript.Frame{{Run: &gptscript.RunFrame{ID: "1", Type: gptscript.EventTypeRunStart}}}
+	var content string
+	for range 300 {
+		content += "a"
+		events = append(events, progress("call1", "", content))
+	}
+
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{Events: events, Output: content})
+
+	run, err := g.Evaluate(context.Background(), gptscript.Options{StreamText: true}, gptscript.ToolDef{Instructions: "Say a lot"})
+	require.NoError(t, err)
+
+	text, err := io.ReadAll(run.TextStream())
+	require.NoError(t, err)
+	require.Equal(t, content, string(text))
+
+	reqs := s.RequestsFor("evaluate")
+	require.Len(t, reqs, 1)
+
+	var req gptscripttest.RunRequest
+	require.NoError(t, reqs[0].Decode(&req))
+	require.True(t, req.IncludeEvents)
+}
+
+func TestTextStreamWithoutEvents(t *testing.T) {
+	s, g := newTestClient(t)
+	s.Script(gptscripttest.Script{Output: "Washington, D.C."})
+
+	run, err := g.Evaluate(context.Background(), gptscript.Options{}, gptscript.ToolDef{Instructions: "What is the capital of the united states?"})
+	require.NoError(t, err)
+
+	b, err := io.ReadAll(run.TextStream())
+	require.NoError(t, err)
+	require.Equal(t, "Washington, D.C.", string(b))
+}
+
+func TestTextStreamError(t *testing.T) {
+	s, g := newTestClient(t)
+	s.HandleRun(func(_ gptscripttest.RunRequest, stream *gptscripttest.Stream) {
+		stream.Fail(500, "model unavailable")
+	})
+
+	run, err := g.Evaluate(context.Background(), gptscript.Options{}, gptscript.ToolDef{Instructions: "What is the capital of the united states?"})
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(run.TextStream())
+	require.ErrorContains(t, err, "model unavailable")
+}
+
+func TestTextStreamRequestError(t *testing.T) {
+	s := gptscripttest.NewServer()
+	s.Close()
+
+	g, err := gptscript.NewGPTScript(gptscript.GlobalOptions{URL: s.URL})
+	require.NoError(t, err)
+	defer g.Close()
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{StreamText: true})
+	require.Error(t, err)
+
+	_, err = io.ReadAll(run.TextStream())
+	require.ErrorAs(t, err, &gptscript.ErrServerUnavailable{})
+}
@@ -0,0 +1,151 @@
+package fakeserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gptscript-ai/go-gptscript"
+	"github.com/gptscript-ai/go-gptscript/pkg/gptscripttest"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTracedClient(t *testing.T) (*gptscripttest.Server, *gptscript.GPTScript, *tracetest.SpanRecorder) {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	s, g := newTestClient(t, gptscript.GlobalOptions{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+		Propagator:     propagation.TraceContext{},
+	})
+
+	return s, g, recorder
+}
+
+func spansByName(spans []sdktrace.ReadOnlySpan) map[string]sdktrace.ReadOnlySpan {
+	byName := make(map[string]sdktrace.ReadOnlySpan, len(spans))
+	for _, span := range spans {
+		byName[span.Name()] = span
+	}
+	return byName
+}
+
+func attributeValue(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestRunTracing(t *testing.T) {
+	s, g, recorder := newTracedClient(t)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	call := func(id, parentID, name string, eventType gptscript.EventType, tokens int) gptscript.Frame {
+		return gptscript.Frame{Call: &gptscript.CallFrame{
+			CallContext: gptscript.CallContext{ID: id, ParentID: parentID, ToolName: name},
+			Type:        eventType,
+			Start:       start,
+			End:         start.Add(time.Second),
+			Usage:       gptscript.Usage{TotalTokens: tokens},
+		}}
+	}
+	s.Script(gptscripttest.Script{
+		Events: []gptscript.Frame{
+			{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart}},
+			call("1", "", "agent", gptscript.EventTypeCallStart, 0),
+			call("2", "1", "search", gptscript.EventTypeCallStart, 0),
+			call("2", "1", "search", gptscript.EventTypeCallFinish, 20),
+			call("1", "", "agent", gptscript.EventTypeCallFinish, 100),
+			{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunFinish}},
+		},
+		Output: "done",
+	})
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{})
+	require.NoError(t, err)
+	_, err = run.Text()
+	require.NoError(t, err)
+
+	require.Len(t, recorder.Ended(), 3)
+	spans := spansByName(recorder.Ended())
+
+	runSpan := spans["gptscript.run"]
+	require.Equal(t, trace.SpanKindClient, runSpan.SpanKind())
+	require.Equal(t, "run1", attributeValue(runSpan, "gptscript.run.id").AsString())
+	require.Equal(t, "test.gpt", attributeValue(runSpan, "gptscript.tool.path").AsString())
+	require.Equal(t, int64(120), attributeValue(runSpan, "gptscript.usage.total_tokens").AsInt64())
+
+	agent := spans["gptscript.call agent"]
+	require.Equal(t, runSpan.SpanContext().SpanID(), agent.Parent().SpanID())
+	require.Equal(t, start, agent.StartTime())
+	require.Equal(t, start.Add(time.Second), agent.EndTime())
+	require.Equal(t, int64(100), attributeValue(agent, "gptscript.usage.total_tokens").AsInt64())
+
+	search := spans["gptscript.call search"]
+	require.Equal(t, agent.SpanContext().SpanID(), search.Parent().SpanID())
+	require.Equal(t, "search", attributeValue(search, "gptscript.tool.name").AsString())
+
+	// The trace context is propagated to the SDK server.
+	requests := s.RequestsFor("run")
+	require.Len(t, requests, 1)
+	traceparent := propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier(requests[0].Header))
+	require.Equal(t, runSpan.SpanContext().TraceID(), trace.SpanContextFromContext(traceparent).TraceID())
+	require.Equal(t, runSpan.SpanContext().SpanID(), trace.SpanContextFromContext(traceparent).SpanID())
+}
+
+func TestCommandTracing(t *testing.T) {
+	s, g, recorder := newTracedClient(t)
+	s.Respond("version", "gptscript version v0.9.5")
+	s.RespondError("workspaces/delete", 500, "failed to delete workspace")
+
+	_, err := g.Version(context.Background())
+	require.NoError(t, err)
+
+	err = g.DeleteWorkspace(context.Background(), "ws1")
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	require.Equal(t, "gptscript.version", spans[0].Name())
+	require.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	require.Equal(t, "gptscript.workspaces/delete", spans[1].Name())
+	require.Equal(t, codes.Error, spans[1].Status().Code)
+	require.Equal(t, int64(500), attributeValue(spans[1], "http.response.status_code").AsInt64())
+
+	// Each command's own span is propagated to the SDK server.
+	requests := s.RequestsFor("workspaces/delete")
+	require.Len(t, requests, 1)
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier(requests[0].Header))
+	require.Equal(t, spans[1].SpanContext().SpanID(), trace.SpanContextFromContext(ctx).SpanID())
+}
+
+func TestBudgetAbortTracing(t *testing.T) {
+	s, g, recorder := newTracedClient(t)
+	s.Script(gptscripttest.Script{Events: budgetEvents, Delay: 50 * time.Millisecond, Output: "done"})
+
+	run, err := g.Run(context.Background(), "test.gpt", gptscript.Options{Budget: gptscript.Budget{MaxTokens: 1000}})
+	require.NoError(t, err)
+	_, err = run.Text()
+	require.ErrorAs(t, err, new(gptscript.ErrBudgetExceeded))
+
+	// The run is aborted with a command, like GPTScript.AbortRun, whose span is a child of the run's span.
+	require.Eventually(t, func() bool {
+		_, ok := spansByName(recorder.Ended())["gptscript.abort"]
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	spans := spansByName(recorder.Ended())
+	require.Equal(t, spans["gptscript.run"].SpanContext().SpanID(), spans["gptscript.abort"].Parent().SpanID())
+	require.Equal(t, "abort/run1", attributeValue(spans["gptscript.abort"], "gptscript.request").AsString())
+	require.Len(t, s.RequestsFor("abort/run1"), 1)
+}
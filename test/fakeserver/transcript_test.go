@@ -0,0 +1,185 @@
+package fakeserver
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gptscript-ai/go-gptscript"
+	"github.com/gptscript-ai/go-gptscript/pkg/gptscripttest"
+	"github.com/stretchr/testify/require"
+)
+
+// chatState returns a chat state like the SDK server's, with a conversation of alternating user and assistant messages.
+// An exchange without input has only the assistant message.
+func chatState(t *testing.T, exchanges ...[2]string) map[string]any {
+	t.Helper()
+
+	messages := []any{
+		map[string]any{"role": "system", "content": []any{map[string]any{"text": "You are a helpful bot."}}},
+	}
+	for _, e := range exchanges {
+		if e[0] != "" {
+			messages = append(messages, map[string]any{"role": "user", "content": []any{map[string]any{"text": e[0]}}})
+		}
+		messages = append(messages, map[string]any{"role": "assistant", "content": []any{map[string]any{"text": e[1]}}})
+	}
+
+	return map[string]any{
+		"continuation":       map[string]any{"state": map[string]any{"completion": map[string]any{"messages": messages}}},
+		"continuationToolID": "chat.gpt:",
+	}
+}
+
+func TestDecodeTranscript(t *testing.T) {
+	transcript, err := gptscript.DecodeTranscript(`{
+		"continuation": {"state": {"completion": {"messages": [
+			{"role": "system", "content": [{"text": "You are a helpful bot."}]},
+			{"role": "user", "content": [{"text": "Find cats"}]},
+			{"role": "assistant", "content": [{"toolCall": {"id": "call_1", "function": {"name": "search", "arguments": "{\"q\":\"cats\"}"}}}]},
+			{"role": "tool", "content": [{"text": "3 results"}], "toolCall": {"id": "call_1", "function": {"name": "search"}}},
+			{"role": "assistant", "content": [{"text": "I found 3 cats."}]}
+		]}}},
+		"continuationToolID": "chat.gpt:"
+	}`)
+	require.NoError(t, err)
+
+	require.Equal(t, []gptscript.TranscriptMessage{
+		{Role: gptscript.TranscriptRoleUser, Content: "Find cats"},
+		{Role: gptscript.TranscriptRoleAssistant, Tool: "chat.gpt:", ToolCalls: []gptscript.TranscriptToolCall{{ID: "call_1", Name: "search", Arguments: `{"q":"cats"}`}}},
+		{Role: gptscript.TranscriptRoleTool, Content: "3 results", Tool: "search", ToolCallID: "call_1"},
+		{Role: gptscript.TranscriptRoleAssistant, Content: "I found 3 cats.", Tool: "chat.gpt:"},
+	}, transcript.Messages)
+
+	require.Equal(t, "### User\n\nFind cats\n\n"+
+		"### Assistant (chat.gpt:)\n\n\n> Called `search` with `{\"q\":\"cats\"}`\n\n"+
+		"### Tool result: search\n\n```\n3 results\n```\n\n"+
+		"### Assistant (chat.gpt:)\n\nI found 3 cats.\n", transcript.Markdown())
+
+	empty, err := gptscript.DecodeTranscript("")
+	require.NoError(t, err)
+	require.Empty(t, empty.Messages)
+
+	_, err = gptscript.DecodeTranscript("{")
+	require.ErrorContains(t, err, "failed to decode chat state")
+}
+
+func TestDecodeTranscriptOfSubCall(t *testing.T) {
+	state, err := json.Marshal(map[string]any{
+		"continuationToolID": "agent.gpt:",
+		"subCallID":          "2",
+		"subCalls": []any{
+			map[string]any{"callId": "1", "state": chatState(t, [2]string{"wrong", "call"})},
+			map[string]any{"callId": "2", "state": chatState(t, [2]string{"hi", "hello from the agent"})},
+		},
+	})
+	require.NoError(t, err)
+
+	// The chat state is sometimes encoded as a JSON string.
+	encoded, err := json.Marshal(string(state))
+	require.NoError(t, err)
+
+	transcript, err := gptscript.DecodeTranscript(string(encoded))
+	require.NoError(t, err)
+	require.Len(t, transcript.Messages, 2)
+	require.Equal(t, "hello from the agent", transcript.Messages[1].Content)
+}
+
+func TestRunTranscript(t *testing.T) {
+	s, g := newTestClient(t)
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.Script(gptscripttest.Script{
+		Events: []gptscript.Frame{
+			{Run: &gptscript.RunFrame{ID: "run1", Type: gptscript.EventTypeRunStart, Program: gptscript.Program{
+				ToolSet: gptscript.ToolSet{"chat.gpt:": gptscript.Tool{ToolDef: gptscript.ToolDef{Name: "chatbot"}}},
+			}}},
+			{Call: &gptscript.CallFrame{
+				CallContext: gptscript.CallContext{ID: "1"},
+				Type:        gptscript.EventTypeCallFinish,
+				Start:       start,
+				End:         start.Add(2 * time.Second),
+			}},
+		},
+		Output: gptscripttest.ChatOutput{
+			Content: "Second answer",
+			ToolID:  "chat.gpt:",
+			State:   chatState(t, [2]string{"First question", "First answer"}, [2]string{"Second question", "Second answer"}),
+		},
+	})
+
+	run, err := g.Run(context.Background(), "chat.gpt", gptscript.Options{IncludeEvents: true, Input: "Second question"})
+	require.NoError(t, err)
+
+	transcript, err := run.Transcript()
+	require.NoError(t, err)
+	require.Len(t, transcript.Messages, 4)
+	require.Equal(t, "chatbot", transcript.Messages[3].Tool)
+
+	// Only the messages of this run's turn have times.
+	require.True(t, transcript.Messages[0].Time.IsZero())
+	require.Equal(t, start, transcript.Messages[2].Time)
+	require.Equal(t, start.Add(2*time.Second), transcript.Messages[3].Time)
+}
+
+func TestChatSessionTranscript(t *testing.T) {
+	s, g := newTestClient(t)
+
+	var exchanges [][2]string
+	s.HandleRun(func(req gptscripttest.RunRequest, stream *gptscripttest.Stream) {
+		if req.Input == "fail" {
+			stream.Fail(500, "model unavailable")
+			return
+		}
+		exchanges = append(exchanges, [2]string{req.Input, "you said " + req.Input})
+		_ = stream.Stdout(gptscripttest.ChatOutput{Content: "you said " + req.Input, State: chatState(t, exchanges...)})
+	})
+
+	session, err := g.NewChatSession(context.Background(), gptscript.NewMemoryChatSessionStore(), gptscript.ChatSessionOptions{ToolPath: "chat.gpt"})
+	require.NoError(t, err)
+
+	for _, input := range []string{"one", "fail", "two"} {
+		_, _ = session.Send(context.Background(), input)
+	}
+
+	transcript, err := session.Transcript()
+	require.NoError(t, err)
+	require.Len(t, transcript.Messages, 4)
+
+	turns := session.Turns()
+	require.Equal(t, "one", transcript.Messages[0].Content)
+	require.Equal(t, turns[0].Start, transcript.Messages[0].Time)
+	require.Equal(t, turns[0].End, transcript.Messages[1].Time)
+	require.Equal(t, "two", transcript.Messages[2].Content)
+	require.Equal(t, turns[2].Start, transcript.Messages[2].Time)
+	require.Equal(t, turns[2].End, transcript.Messages[3].Time)
+}
+
+func TestChatSessionTranscriptEmptyInput(t *testing.T) {
+	s, g := newTestClient(t)
+
+	var exchanges [][2]string
+	s.HandleRun(func(req gptscripttest.RunRequest, stream *gptscripttest.Stream) {
+		exchanges = append(exchanges, [2]string{req.Input, "you said " + req.Input})
+		_ = stream.Stdout(gptscripttest.ChatOutput{Content: "you said " + req.Input, State: chatState(t, exchanges...)})
+	})
+
+	session, err := g.NewChatSession(context.Background(), gptscript.NewMemoryChatSessionStore(), gptscript.ChatSessionOptions{ToolPath: "chat.gpt"})
+	require.NoError(t, err)
+
+	for _, input := range []string{"one", ""} {
+		_, err = session.Send(context.Background(), input)
+		require.NoError(t, err)
+	}
+
+	transcript, err := session.Transcript()
+	require.NoError(t, err)
+	require.Len(t, transcript.Messages, 3)
+
+	// The turn without input has no user message, so it doesn't take the times of the turn before it.
+	turns := session.Turns()
+	require.Equal(t, turns[0].Start, transcript.Messages[0].Time)
+	require.Equal(t, turns[0].End, transcript.Messages[1].Time)
+	require.Equal(t, turns[1].End, transcript.Messages[2].Time)
+}
@@ -0,0 +1,125 @@
+package gptscript
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+// textBuffer collects the text of the entry call of a run as it is generated, for the readers returned by
+// Run.TextStream. The text is kept, so that each reader gets all of it no matter when it was created.
+type textBuffer struct {
+	lock sync.Mutex
+	cond *sync.Cond
+	text strings.Builder
+	// callID is the ID of the entry call, and sent is the length of the content of each of its outputs that was added.
+	callID string
+	sent   []int
+	closed bool
+	err    error
+}
+
+func newTextBuffer() *textBuffer {
+	b := &textBuffer{}
+	b.cond = sync.NewCond(&b.lock)
+	return b
+}
+
+// call adds the content of the call's outputs that wasn't added yet, if the call is the entry call of the run.
+func (b *textBuffer) call(call CallFrame) {
+	if b == nil {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.callID == "" && call.ParentID == "" && call.ToolCategory == NoCategory {
+		b.callID = call.ID
+	}
+	if b.closed || call.ID != b.callID {
+		return
+	}
+
+	var added bool
+	// The content of each output is cumulative, and there is a new output for each response of the model.
+	for i, out := range call.Output {
+		if i == len(b.sent) {
+			b.sent = append(b.sent, 0)
+		}
+		if len(out.Content) > b.sent[i] {
+			b.text.WriteString(out.Content[b.sent[i]:])
+			b.sent[i] = len(out.Content)
+			added = true
+		}
+	}
+
+	if added {
+		b.cond.Broadcast()
+	}
+}
+
+// close ends the text. If none of it was streamed, because the run didn't include events, then the output of the run is
+// the text.
+func (b *textBuffer) close(output string, err error) {
+	if b == nil {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.text.Len() == 0 {
+		b.text.WriteString(output)
+	}
+	b.closed = true
+	b.err = err
+	b.cond.Broadcast()
+}
+
+// textReader reads the text of a textBuffer from the beginning.
+type textReader struct {
+	buf    *textBuffer
+	offset int
+}
+
+func (r *textReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	r.buf.lock.Lock()
+	defer r.buf.lock.Unlock()
+
+	for r.offset == r.buf.text.Len() && !r.buf.closed {
+		r.buf.cond.Wait()
+	}
+
+	if r.offset == r.buf.text.Len() {
+		if r.buf.err != nil {
+			return 0, r.buf.err
+		}
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.buf.text.String()[r.offset:])
+	r.offset += n
+	return n, nil
+}
+
+// TextStream returns a reader of the text of the run's entry call as it is generated, so that it can be copied to an
+// HTTP response or a terminal without waiting for the run to finish. The text is streamed only if the run includes
+// events, so set StreamText in the Options; otherwise, all the output is read once the run finishes. If IncludeEvents
+// is set instead, then the events must also be received from Run.Events, or the run stops once their buffer is full.
+// Reading returns io.EOF after the end of the text, or the error of the run if it failed, including when its request
+// couldn't be made. Each reader returned by TextStream reads the text from the beginning.
+func (r *Run) TextStream() io.Reader {
+	if r.text == nil {
+		if err := r.Err(); err != nil {
+			return errorReader{err: err}
+		}
+		return errorReader{err: errors.New("run not started")}
+	}
+	return &textReader{buf: r.text}
+}
@@ -0,0 +1,167 @@
+package gptscript
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/gptscript-ai/go-gptscript"
+
+// tracer returns the tracer for the spans of runs and commands.
+func (g GlobalOptions) tracer() trace.Tracer {
+	provider := g.TracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(tracerName)
+}
+
+// propagator returns the propagator of the trace context to the SDK server.
+func (g GlobalOptions) propagator() propagation.TextMapPropagator {
+	if g.Propagator != nil {
+		return g.Propagator
+	}
+	return otel.GetTextMapPropagator()
+}
+
+// commandName returns the name of a command, which is its request path without the IDs that are part of some paths.
+func commandName(requestPath string) string {
+	for _, prefix := range []string{"abort/", "confirm/", "prompt-response/", "resume/"} {
+		if strings.HasPrefix(requestPath, prefix) {
+			return strings.TrimSuffix(prefix, "/")
+		}
+	}
+	return requestPath
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error, opts ...trace.SpanEndOption) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(opts...)
+}
+
+// runTrace is the span of a run and the spans of its calls, which are children of the spans of their parent calls.
+type runTrace struct {
+	tracer trace.Tracer
+	ctx    context.Context
+	span   trace.Span
+
+	lock  sync.Mutex
+	calls map[string]callSpan
+}
+
+type callSpan struct {
+	ctx  context.Context
+	span trace.Span
+}
+
+// startRunTrace starts the span of a run. The returned context carries the span, so it is propagated to the SDK server.
+func startRunTrace(ctx context.Context, r *Run) (context.Context, *runTrace) {
+	attrs := []attribute.KeyValue{attribute.String("gptscript.request", r.requestPath)}
+	if r.toolPath != "" {
+		attrs = append(attrs, attribute.String("gptscript.tool.path", r.toolPath))
+	}
+
+	ctx, span := r.tracer.Start(ctx, "gptscript.run", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return ctx, &runTrace{
+		tracer: r.tracer,
+		ctx:    ctx,
+		span:   span,
+		calls:  make(map[string]callSpan),
+	}
+}
+
+// runStarted records the ID of the run.
+func (t *runTrace) runStarted(id string) {
+	if t == nil {
+		return
+	}
+	t.span.SetAttributes(attribute.String("gptscript.run.id", id))
+}
+
+// call starts the span of the call the first time it is seen, and ends it when the call finishes.
+func (t *runTrace) call(frame CallFrame) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	cs, ok := t.calls[frame.ID]
+	if !ok {
+		// Calls are nested by their parent, if its span was started, and otherwise are children of the run.
+		parent := t.ctx
+		if p, ok := t.calls[frame.ParentID]; ok && frame.ParentID != "" {
+			parent = p.ctx
+		}
+
+		cs.ctx, cs.span = t.tracer.Start(parent, "gptscript.call "+frame.ToolName,
+			trace.WithTimestamp(firstSet(frame.Start, time.Now())),
+			trace.WithAttributes(
+				attribute.String("gptscript.call.id", frame.ID),
+				attribute.String("gptscript.tool.name", frame.ToolName),
+				attribute.String("gptscript.tool.category", string(frame.ToolCategory)),
+			),
+		)
+		t.calls[frame.ID] = cs
+	}
+
+	if frame.Type != EventTypeCallFinish || !cs.span.IsRecording() {
+		return
+	}
+
+	cs.span.SetAttributes(usageAttributes(frame.Usage)...)
+	if frame.Tool.ModelName != "" {
+		cs.span.SetAttributes(attribute.String("gptscript.model", frame.Tool.ModelName))
+	}
+	if frame.ChatResponseCached {
+		cs.span.SetAttributes(attribute.Bool("gptscript.chat_response_cached", true))
+	}
+	cs.span.End(trace.WithTimestamp(firstSet(frame.End, time.Now())))
+}
+
+// end ends the spans of the calls that didn't finish, and then the span of the run.
+func (t *runTrace) end(usage Usage, err error) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	for _, cs := range t.calls {
+		if cs.span.IsRecording() {
+			cs.span.End()
+		}
+	}
+	t.lock.Unlock()
+
+	t.span.SetAttributes(usageAttributes(usage)...)
+	endSpan(t.span, err)
+}
+
+func usageAttributes(usage Usage) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int("gptscript.usage.prompt_tokens", usage.PromptTokens),
+		attribute.Int("gptscript.usage.completion_tokens", usage.CompletionTokens),
+		attribute.Int("gptscript.usage.total_tokens", usage.TotalTokens),
+	}
+}
+
+// injectTraceContext adds the trace context of ctx to the headers of a request to the SDK server.
+func (r *Run) injectTraceContext(ctx context.Context, req *http.Request) {
+	if r.propagator != nil {
+		r.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	}
+}
@@ -0,0 +1,337 @@
+package gptscript
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TranscriptRole is the role of the author of a message in a Transcript.
+type TranscriptRole string
+
+const (
+	TranscriptRoleUser      TranscriptRole = "user"
+	TranscriptRoleAssistant TranscriptRole = "assistant"
+	TranscriptRoleTool      TranscriptRole = "tool"
+)
+
+// Transcript is the conversation of a chat, in order.
+type Transcript struct {
+	Messages []TranscriptMessage `json:"messages"`
+}
+
+// TranscriptMessage is a message of a chat.
+type TranscriptMessage struct {
+	Role    TranscriptRole `json:"role"`
+	Content string         `json:"content,omitempty"`
+	// Tool is the tool that responded, for assistant messages, or the tool that was called, for tool messages.
+	Tool string `json:"tool,omitempty"`
+	// ToolCallID is the ID of the tool call that a tool message is the result of.
+	ToolCallID string `json:"toolCallID,omitempty"`
+	// ToolCalls are the tools that an assistant message called.
+	ToolCalls []TranscriptToolCall `json:"toolCalls,omitempty"`
+	// Time is when the message was sent, if it is known. The chat state doesn't have the times of messages, so only the
+	// messages of the turns of a run or a ChatSession have one.
+	Time time.Time `json:"time,omitzero"`
+}
+
+// TranscriptToolCall is a call to a tool made by an assistant message.
+type TranscriptToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// chatStateJSON is the part of the chat state, as the SDK server returns it, that has the messages of a chat.
+type chatStateJSON struct {
+	Continuation *struct {
+		State *struct {
+			Completion struct {
+				Messages []chatMessageJSON `json:"messages"`
+			} `json:"completion"`
+		} `json:"state"`
+	} `json:"continuation"`
+	ContinuationToolID string `json:"continuationToolID"`
+	SubCalls           []struct {
+		CallID string         `json:"callId"`
+		State  *chatStateJSON `json:"state"`
+	} `json:"subCalls"`
+	SubCallID string `json:"subCallID"`
+}
+
+type chatMessageJSON struct {
+	Role    string `json:"role"`
+	Content []struct {
+		Text     string            `json:"text"`
+		ToolCall *chatToolCallJSON `json:"toolCall"`
+	} `json:"content"`
+	ToolCall *chatToolCallJSON `json:"toolCall"`
+}
+
+type chatToolCallJSON struct {
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// DecodeTranscript returns the conversation in a chat state, like the one returned by Run.ChatState. The system
+// messages, which are the instructions of the tool, are not included. Assistant messages have the ID of the tool that
+// responded, rather than its name, because the chat state doesn't have the program.
+func DecodeTranscript(chatState string) (Transcript, error) {
+	t, _, err := decodeTranscript(chatState)
+	return t, err
+}
+
+// decodeTranscript returns the conversation in the chat state and the ID of the tool that is chatting.
+func decodeTranscript(chatState string) (Transcript, string, error) {
+	if chatState == "" || chatState == "null" {
+		return Transcript{}, "", nil
+	}
+
+	data := []byte(chatState)
+	// The state is sometimes encoded again as a JSON string.
+	var encoded string
+	if json.Unmarshal(data, &encoded) == nil {
+		data = []byte(encoded)
+	}
+
+	var state chatStateJSON
+	if err := json.Unmarshal(data, &state); err != nil {
+		return Transcript{}, "", fmt.Errorf("failed to decode chat state: %w", err)
+	}
+
+	// When a tool called by the entry tool is chatting, the conversation is in the state of that call.
+	current := &state
+	for current.Continuation == nil && len(current.SubCalls) > 0 {
+		next := current.SubCalls[len(current.SubCalls)-1].State
+		for _, subCall := range current.SubCalls {
+			if subCall.CallID == current.SubCallID && subCall.State != nil {
+				next = subCall.State
+			}
+		}
+		if next == nil {
+			break
+		}
+		current = next
+	}
+
+	if current.Continuation == nil || current.Continuation.State == nil {
+		return Transcript{}, current.ContinuationToolID, nil
+	}
+
+	toolID := firstSet(current.ContinuationToolID, state.ContinuationToolID)
+
+	var t Transcript
+	for _, msg := range current.Continuation.State.Completion.Messages {
+		var (
+			text      strings.Builder
+			toolCalls []TranscriptToolCall
+		)
+		for _, part := range msg.Content {
+			text.WriteString(part.Text)
+			if part.ToolCall != nil {
+				toolCalls = append(toolCalls, TranscriptToolCall{
+					ID:        part.ToolCall.ID,
+					Name:      part.ToolCall.Function.Name,
+					Arguments: part.ToolCall.Function.Arguments,
+				})
+			}
+		}
+
+		switch TranscriptRole(msg.Role) {
+		case TranscriptRoleUser:
+			t.Messages = append(t.Messages, TranscriptMessage{Role: TranscriptRoleUser, Content: text.String()})
+		case TranscriptRoleAssistant:
+			t.Messages = append(t.Messages, TranscriptMessage{
+				Role:      TranscriptRoleAssistant,
+				Content:   text.String(),
+				Tool:      toolID,
+				ToolCalls: toolCalls,
+			})
+		case TranscriptRoleTool:
+			m := TranscriptMessage{Role: TranscriptRoleTool, Content: text.String()}
+			if msg.ToolCall != nil {
+				m.Tool = msg.ToolCall.Function.Name
+				m.ToolCallID = msg.ToolCall.ID
+			}
+			t.Messages = append(t.Messages, m)
+		}
+	}
+
+	return t, toolID, nil
+}
+
+// Transcript returns the conversation of the chat so far, from the chat state of the run. Assistant messages have the
+// name of the tool that responded, and the messages of this run's turn have the times of the run's entry call.
+// It blocks until the run has finished.
+func (r *Run) Transcript() (Transcript, error) {
+	r.lock.Lock()
+	chatState, program := r.chatState, r.program
+	r.lock.Unlock()
+
+	t, toolID, err := decodeTranscript(chatState)
+	if err != nil {
+		return Transcript{}, err
+	}
+
+	if program != nil {
+		if tool, ok := program.ToolSet[toolID]; ok {
+			t.setTool(toolID, tool.Name)
+		}
+	}
+
+	if call, ok := r.ParentCallFrame(); ok && !call.Start.IsZero() {
+		if start := t.turnStart(len(t.Messages), r.opts.Input); start >= 0 {
+			Transcript{Messages: t.Messages[start:]}.setTurnTimes(call.Start, call.End)
+		}
+	}
+
+	return t, nil
+}
+
+// Transcript returns the conversation of the session so far, from its chat state. The messages of each turn have the
+// times the turn was sent and answered.
+func (s *ChatSession) Transcript() (Transcript, error) {
+	s.lock.Lock()
+	chatState, turns := s.record.ChatState, s.record.Turns
+	s.lock.Unlock()
+
+	t, _, err := decodeTranscript(chatState)
+	if err != nil {
+		return Transcript{}, err
+	}
+
+	// The turns that didn't fail are matched to the user messages, from the last one, because the chat state only has
+	// the turns that succeeded.
+	end := len(t.Messages)
+	for i := len(turns) - 1; i >= 0; i-- {
+		turn := turns[i]
+		if turn.Error != "" {
+			continue
+		}
+
+		start := t.turnStart(end, turn.Input)
+		if start < 0 {
+			break
+		}
+
+		turnTranscript := Transcript{Messages: t.Messages[start:end]}
+		turnTranscript.setTurnTimes(turn.Start, turn.End)
+		if turn.Tool != "" {
+			turnTranscript.setAssistantTool(turn.Tool)
+		}
+		end = start
+	}
+
+	return t, nil
+}
+
+// setTool replaces the ID of the responding tool of the assistant messages with its name.
+func (t Transcript) setTool(id, name string) {
+	for i := range t.Messages {
+		if t.Messages[i].Role == TranscriptRoleAssistant && t.Messages[i].Tool == id {
+			t.Messages[i].Tool = name
+		}
+	}
+}
+
+func (t Transcript) setAssistantTool(name string) {
+	for i := range t.Messages {
+		if t.Messages[i].Role == TranscriptRoleAssistant {
+			t.Messages[i].Tool = name
+		}
+	}
+}
+
+// setTurnTimes sets the times of the messages of a turn: the user message that starts the turn, if it has one, is sent
+// at the start of the turn, and the last assistant message at the end of it.
+func (t Transcript) setTurnTimes(start, end time.Time) {
+	if len(t.Messages) > 0 && t.Messages[0].Role == TranscriptRoleUser {
+		t.Messages[0].Time = start
+	}
+
+	if end.IsZero() {
+		return
+	}
+	for i := len(t.Messages) - 1; i >= 0 && t.Messages[i].Role != TranscriptRoleUser; i-- {
+		if t.Messages[i].Role == TranscriptRoleAssistant {
+			t.Messages[i].Time = end
+			return
+		}
+	}
+}
+
+// turnStart returns the index of the first message of the turn with the input whose messages end before end, or -1 if
+// there isn't one. A turn with input starts with the last user message before end with that content. A turn without
+// input may not have a user message, so it starts after the messages of the turn before it, which end with a user
+// message or an assistant message that didn't call tools.
+func (t Transcript) turnStart(end int, input string) int {
+	if input != "" {
+		for i := end - 1; i >= 0; i-- {
+			if t.Messages[i].Role == TranscriptRoleUser && t.Messages[i].Content == input {
+				return i
+			}
+		}
+		return -1
+	}
+
+	if end == 0 {
+		return -1
+	}
+	// The last message is the answer of the turn itself.
+	for i := end - 2; i >= 0; i-- {
+		m := t.Messages[i]
+		switch {
+		case m.Role == TranscriptRoleUser && m.Content == "":
+			return i
+		case m.Role == TranscriptRoleUser, m.Role == TranscriptRoleAssistant && len(m.ToolCalls) == 0:
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Markdown returns the transcript as Markdown, with a heading for each message.
+func (t Transcript) Markdown() string {
+	var b strings.Builder
+	for i, m := range t.Messages {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		switch m.Role {
+		case TranscriptRoleUser:
+			b.WriteString("### User")
+		case TranscriptRoleAssistant:
+			b.WriteString("### Assistant")
+			if m.Tool != "" {
+				fmt.Fprintf(&b, " (%s)", m.Tool)
+			}
+		case TranscriptRoleTool:
+			fmt.Fprintf(&b, "### Tool result: %s", firstSet(m.Tool, m.ToolCallID))
+		}
+		if !m.Time.IsZero() {
+			fmt.Fprintf(&b, " — %s", m.Time.Format(time.DateTime))
+		}
+		b.WriteString("\n\n")
+
+		if m.Role == TranscriptRoleTool {
+			b.WriteString("```\n" + strings.TrimSuffix(m.Content, "\n") + "\n```\n")
+		} else if m.Content != "" {
+			b.WriteString(strings.TrimSuffix(m.Content, "\n") + "\n")
+		}
+
+		for _, call := range m.ToolCalls {
+			fmt.Fprintf(&b, "\n> Called `%s`", call.Name)
+			if call.Arguments != "" {
+				fmt.Fprintf(&b, " with `%s`", call.Arguments)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,134 @@
+package gptscript
+
+import (
+	"slices"
+	"strings"
+)
+
+// ModelPrice is the price of a model, in any currency, per million tokens.
+type ModelPrice struct {
+	PromptPerMillion     float64
+	CompletionPerMillion float64
+}
+
+// Cost returns the cost of the usage at this price.
+func (p ModelPrice) Cost(u Usage) float64 {
+	return (float64(u.PromptTokens)*p.PromptPerMillion + float64(u.CompletionTokens)*p.CompletionPerMillion) / 1_000_000
+}
+
+// PricingTable is the prices of models by their name. The name of a model from a provider, like
+// "claude-3-5-sonnet from github.com/gptscript-ai/claude3-anthropic-provider", can be either the full name or the
+// part before " from ".
+type PricingTable map[string]ModelPrice
+
+// price returns the price of the model, if it is in the table.
+func (t PricingTable) price(model string) (ModelPrice, bool) {
+	if p, ok := t[model]; ok {
+		return p, true
+	}
+
+	name, _, found := strings.Cut(model, " from ")
+	if !found {
+		return ModelPrice{}, false
+	}
+
+	p, ok := t[name]
+	return p, ok
+}
+
+// UsageStats is the usage of a group of calls.
+type UsageStats struct {
+	// Usage is the usage of the calls that weren't cached.
+	Usage Usage
+	// CachedUsage is the usage reported for the calls with a cached response, which didn't consume any tokens.
+	CachedUsage Usage
+	Calls       int
+	CachedCalls int
+	// Cost is the estimated cost of Usage. It is zero if there is no pricing table or the models aren't in it.
+	Cost float64
+	// Savings is the estimated cost of CachedUsage, which was saved because the responses were cached.
+	Savings float64
+}
+
+func (s *UsageStats) add(call CallFrame, price ModelPrice) {
+	if call.ChatResponseCached {
+		s.CachedCalls++
+		s.CachedUsage = addUsage(s.CachedUsage, call.Usage)
+		s.Savings += price.Cost(call.Usage)
+		return
+	}
+
+	s.Calls++
+	s.Usage = addUsage(s.Usage, call.Usage)
+	s.Cost += price.Cost(call.Usage)
+}
+
+func addUsage(a, b Usage) Usage {
+	return Usage{
+		PromptTokens:     a.PromptTokens + b.PromptTokens,
+		CompletionTokens: a.CompletionTokens + b.CompletionTokens,
+		TotalTokens:      a.TotalTokens + b.TotalTokens,
+	}
+}
+
+// DefaultModelKey is the model that the usage of tools without a model name is reported under, when the default model
+// they use isn't known.
+const DefaultModelKey = "default"
+
+// UsageReport breaks down the usage of a run by model, tool, and tool category. Only the calls that used a model, or
+// had a cached response, are included.
+type UsageReport struct {
+	Total      UsageStats
+	ByModel    map[string]UsageStats
+	ByTool     map[string]UsageStats
+	ByCategory map[ToolCategory]UsageStats
+	// UnpricedModels are the models that were used but aren't in the pricing table, so their cost isn't included.
+	UnpricedModels []string
+}
+
+// UsageReport returns the usage of the run so far, broken down by model, tool, and tool category. The cost is estimated
+// with the given prices, which can be nil. The usage of tools without a model name is reported under the DefaultModel
+// of the run's options, or DefaultModelKey if it isn't set.
+func (r *Run) UsageReport(prices PricingTable) UsageReport {
+	return newUsageReport(r.Calls(), prices, r.opts.DefaultModel)
+}
+
+// NewUsageReport returns the usage of the calls, broken down by model, tool, and tool category. The cost is estimated
+// with the given prices, which can be nil. The usage of tools without a model name is reported under DefaultModelKey.
+func NewUsageReport(calls CallFrames, prices PricingTable) UsageReport {
+	return newUsageReport(calls, prices, "")
+}
+
+func newUsageReport(calls CallFrames, prices PricingTable, defaultModel string) UsageReport {
+	report := UsageReport{
+		ByModel:    make(map[string]UsageStats),
+		ByTool:     make(map[string]UsageStats),
+		ByCategory: make(map[ToolCategory]UsageStats),
+	}
+
+	for _, call := range calls {
+		if call.Usage == (Usage{}) && !call.ChatResponseCached {
+			continue
+		}
+
+		model := firstSet(call.Tool.ModelName, defaultModel, DefaultModelKey)
+		price, ok := prices.price(model)
+		if !ok && prices != nil && !slices.Contains(report.UnpricedModels, model) {
+			report.UnpricedModels = append(report.UnpricedModels, model)
+		}
+
+		report.Total.add(call, price)
+		addTo(report.ByModel, model, call, price)
+		addTo(report.ByTool, firstSet(call.ToolName, call.Tool.Name), call, price)
+		addTo(report.ByCategory, call.ToolCategory, call, price)
+	}
+
+	slices.Sort(report.UnpricedModels)
+	return report
+}
+
+func addTo[K comparable](m map[K]UsageStats, key K, call CallFrame, price ModelPrice) {
+	stats := m[key]
+	stats.add(call, price)
+	m[key] = stats
+}
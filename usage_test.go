@@ -0,0 +1,79 @@
+package gptscript
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageReport(t *testing.T) {
+	modelCall := func(id, tool, model string, category ToolCategory, prompt, completion int, cached bool) CallFrame {
+		return CallFrame{
+			CallContext: CallContext{
+				ID:           id,
+				ToolName:     tool,
+				ToolCategory: category,
+				Tool:         Tool{ToolDef: ToolDef{ModelName: model}},
+			},
+			Usage:              Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion},
+			ChatResponseCached: cached,
+		}
+	}
+
+	report := NewUsageReport(CallFrames{
+		"1": modelCall("1", "agent", "gpt-4o", NoCategory, 1000, 500, false),
+		"2": modelCall("2", "agent", "gpt-4o", NoCategory, 2000, 0, true),
+		"3": modelCall("3", "summarize", "claude-3-5-sonnet from github.com/gptscript-ai/claude3-anthropic-provider", NoCategory, 3000, 1000, false),
+		"4": modelCall("4", "credential", "local-model", CredentialToolCategory, 100, 100, false),
+		// Calls that didn't use a model are not included.
+		"5": {CallContext: CallContext{ID: "5", ToolName: "sys.read"}},
+		// The tool doesn't have a model name, so it uses the default model.
+		"6": modelCall("6", "agent", "", NoCategory, 10, 10, false),
+	}, PricingTable{
+		"gpt-4o":            {PromptPerMillion: 2.5, CompletionPerMillion: 10},
+		"claude-3-5-sonnet": {PromptPerMillion: 3, CompletionPerMillion: 15},
+	})
+
+	require.Equal(t, 4, report.Total.Calls)
+	require.Equal(t, 1, report.Total.CachedCalls)
+	require.Equal(t, Usage{PromptTokens: 4110, CompletionTokens: 1610, TotalTokens: 5720}, report.Total.Usage)
+	require.InDelta(t, 0.0025+0.005+0.009+0.015, report.Total.Cost, 1e-9)
+	require.InDelta(t, 0.005, report.Total.Savings, 1e-9)
+
+	gpt := report.ByModel["gpt-4o"]
+	require.Equal(t, 1, gpt.Calls)
+	require.Equal(t, 1, gpt.CachedCalls)
+	require.Equal(t, Usage{PromptTokens: 2000, TotalTokens: 2000}, gpt.CachedUsage)
+	require.InDelta(t, 0.0075, gpt.Cost, 1e-9)
+
+	require.Len(t, report.ByTool, 3)
+	require.InDelta(t, 0.024, report.ByTool["summarize"].Cost, 1e-9)
+	require.Equal(t, 1, report.ByCategory[CredentialToolCategory].Calls)
+	require.Equal(t, 4, report.ByCategory[NoCategory].Calls+report.ByCategory[NoCategory].CachedCalls)
+	require.Equal(t, 1, report.ByModel[DefaultModelKey].Calls)
+	require.NotContains(t, report.ByModel, "")
+	require.Equal(t, []string{DefaultModelKey, "local-model"}, report.UnpricedModels)
+}
+
+func TestUsageReportWithoutPrices(t *testing.T) {
+	report := NewUsageReport(CallFrames{
+		"1": {CallContext: CallContext{ID: "1"}, Usage: Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}},
+	}, nil)
+
+	require.Equal(t, 15, report.Total.Usage.TotalTokens)
+	require.Zero(t, report.Total.Cost)
+	require.Empty(t, report.UnpricedModels)
+}
+
+func TestRunUsageReportDefaultModel(t *testing.T) {
+	run := &Run{
+		opts: Options{GlobalOptions: GlobalOptions{DefaultModel: "gpt-4o-mini"}},
+		calls: CallFrames{
+			"1": {CallContext: CallContext{ID: "1"}, Usage: Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}},
+		},
+	}
+
+	report := run.UsageReport(PricingTable{"gpt-4o-mini": {PromptPerMillion: 0.15, CompletionPerMillion: 0.6}})
+	require.Equal(t, 15, report.ByModel["gpt-4o-mini"].Usage.TotalTokens)
+	require.Empty(t, report.UnpricedModels)
+}
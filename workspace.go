@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
@@ -13,24 +14,99 @@ import (
 
 var conflictErrParser = regexp.MustCompile(`^.+500 Internal Server Error: conflict: (.+)/([^/]+) \(latest revision: (-?\d+), current revision: (-?\d+)\)$`)
 
+var (
+	// ErrWorkspaceFileNotFound matches any NotFoundInWorkspaceError with errors.Is.
+	ErrWorkspaceFileNotFound = errors.New("not found in workspace")
+	// ErrWorkspaceConflict matches any ConflictInWorkspaceError with errors.Is.
+	ErrWorkspaceConflict = errors.New("conflict in workspace")
+)
+
+// NotFoundInWorkspaceError is returned when a file or revision doesn't exist in a workspace.
 type NotFoundInWorkspaceError struct {
-	id   string
-	name string
+	// ID is the ID of the workspace.
+	ID string
+	// Name is the path of the file that wasn't found, or a description of the revision that wasn't found.
+	Name string
+
+	// err is the error returned by the SDK server, like a RunError, if any.
+	err error
 }
 
 func (e *NotFoundInWorkspaceError) Error() string {
-	return fmt.Sprintf("not found: %s/%s", e.id, e.name)
+	return fmt.Sprintf("not found: %s/%s", e.ID, e.Name)
 }
 
-func newNotFoundInWorkspaceError(id, name string) *NotFoundInWorkspaceError {
-	return &NotFoundInWorkspaceError{id: id, name: name}
+func (e *NotFoundInWorkspaceError) Is(target error) bool {
+	return target == ErrWorkspaceFileNotFound
 }
 
+func (e *NotFoundInWorkspaceError) Unwrap() error {
+	return e.err
+}
+
+func newNotFoundInWorkspaceError(id, name string, err error) *NotFoundInWorkspaceError {
+	return &NotFoundInWorkspaceError{ID: id, Name: name, err: err}
+}
+
+// ConflictInWorkspaceError is returned when a file is written with a LatestRevisionID that is no longer the latest revision.
 type ConflictInWorkspaceError struct {
 	ID              string
 	Name            string
 	LatestRevision  string
 	CurrentRevision string
+
+	// err is the error returned by the SDK server, like a RunError, if any.
+	err error
+}
+
+// workspaceErrorDetails is the structured error that newer SDK servers return when a workspace command fails.
+// This shape, with a "code" of "notFound" or "conflict", is assumed rather than taken from a released SDK server, so
+// the error message is still parsed when the details don't match it.
+type workspaceErrorDetails struct {
+	Code              string `json:"code"`
+	WorkspaceID       string `json:"workspaceID"`
+	FilePath          string `json:"filePath"`
+	LatestRevisionID  string `json:"latestRevisionID"`
+	CurrentRevisionID string `json:"currentRevisionID"`
+}
+
+const (
+	workspaceErrorCodeNotFound = "notFound"
+	workspaceErrorCodeConflict = "conflict"
+)
+
+// parseWorkspaceError converts the error of a workspace command for the given file to a NotFoundInWorkspaceError or
+// a ConflictInWorkspaceError, if it is one. The structured error from the SDK server is used when there is one,
+// otherwise the error message is parsed for older servers.
+func parseWorkspaceError(err error, workspaceID, filePath string) error {
+	if err == nil {
+		return nil
+	}
+
+	var runErr RunError
+	if errors.As(err, &runErr) && len(runErr.Details) > 0 {
+		var details workspaceErrorDetails
+		if json.Unmarshal(runErr.Details, &details) == nil {
+			switch details.Code {
+			case workspaceErrorCodeNotFound:
+				return newNotFoundInWorkspaceError(firstSet(details.WorkspaceID, workspaceID), firstSet(details.FilePath, filePath), err)
+			case workspaceErrorCodeConflict:
+				return &ConflictInWorkspaceError{
+					ID:              firstSet(details.WorkspaceID, workspaceID),
+					Name:            firstSet(details.FilePath, filePath),
+					LatestRevision:  details.LatestRevisionID,
+					CurrentRevision: details.CurrentRevisionID,
+					err:             err,
+				}
+			}
+		}
+	}
+
+	if strings.HasSuffix(err.Error(), fmt.Sprintf("not found: %s/%s", workspaceID, filePath)) {
+		return newNotFoundInWorkspaceError(workspaceID, filePath, err)
+	}
+
+	return parsePossibleConflictInWorkspaceError(err)
 }
 
 func parsePossibleConflictInWorkspaceError(err error) error {
@@ -42,19 +118,27 @@ func parsePossibleConflictInWorkspaceError(err error) error {
 	if len(matches) != 5 {
 		return err
 	}
-	return &ConflictInWorkspaceError{ID: matches[1], Name: matches[2], LatestRevision: matches[3], CurrentRevision: matches[4]}
+	return &ConflictInWorkspaceError{ID: matches[1], Name: matches[2], LatestRevision: matches[3], CurrentRevision: matches[4], err: err}
 }
 
 func (e *ConflictInWorkspaceError) Error() string {
 	return fmt.Sprintf("conflict: %s/%s (latest revision: %s, current revision: %s)", e.ID, e.Name, e.LatestRevision, e.CurrentRevision)
 }
 
+func (e *ConflictInWorkspaceError) Is(target error) bool {
+	return target == ErrWorkspaceConflict
+}
+
+func (e *ConflictInWorkspaceError) Unwrap() error {
+	return e.err
+}
+
 func (g *GPTScript) CreateWorkspace(ctx context.Context, providerType string, fromWorkspaces ...string) (string, error) {
 	out, err := g.runBasicCommand(ctx, "workspaces/create", map[string]any{
 		"providerType":     providerType,
 		"fromWorkspaceIDs": fromWorkspaces,
 		"workspaceTool":    g.globalOpts.WorkspaceTool,
-		"env":              g.globalOpts.Env,
+		"env":              g.options().Env,
 	})
 	if err != nil {
 		return "", err
@@ -71,7 +155,7 @@ func (g *GPTScript) DeleteWorkspace(ctx context.Context, workspaceID string) err
 	_, err := g.runBasicCommand(ctx, "workspaces/delete", map[string]any{
 		"id":            workspaceID,
 		"workspaceTool": g.globalOpts.WorkspaceTool,
-		"env":           g.globalOpts.Env,
+		"env":           g.options().Env,
 	})
 
 	return err
@@ -97,11 +181,11 @@ func (g *GPTScript) ListFilesInWorkspace(ctx context.Context, opts ...ListFilesI
 		opt.WorkspaceID = os.Getenv("GPTSCRIPT_WORKSPACE_ID")
 	}
 
-	out, err := g.runBasicCommand(ctx, "workspaces/list", map[string]any{
+	out, err := g.runRetryableCommand(ctx, "workspaces/list", map[string]any{
 		"id":            opt.WorkspaceID,
 		"prefix":        opt.Prefix,
 		"workspaceTool": g.globalOpts.WorkspaceTool,
-		"env":           g.globalOpts.Env,
+		"env":           g.options().Env,
 	})
 	if err != nil {
 		return nil, err
@@ -140,7 +224,7 @@ func (g *GPTScript) RemoveAll(ctx context.Context, opts ...RemoveAllOptions) err
 		"id":            opt.WorkspaceID,
 		"prefix":        opt.WithPrefix,
 		"workspaceTool": g.globalOpts.WorkspaceTool,
-		"env":           g.globalOpts.Env,
+		"env":           g.options().Env,
 	})
 
 	return err
@@ -170,17 +254,24 @@ func (g *GPTScript) WriteFileInWorkspace(ctx context.Context, filePath string, c
 		opt.WorkspaceID = os.Getenv("GPTSCRIPT_WORKSPACE_ID")
 	}
 
-	_, err := g.runBasicCommand(ctx, "workspaces/write-file", map[string]any{
+	// Writes are only retried with a revision precondition, because a repeated write then fails with a conflict
+	// instead of creating another revision.
+	runCommand := g.runBasicCommand
+	if opt.LatestRevisionID != "" {
+		runCommand = g.runRetryableCommand
+	}
+
+	_, err := runCommand(ctx, "workspaces/write-file", map[string]any{
 		"id":               opt.WorkspaceID,
 		"contents":         base64.StdEncoding.EncodeToString(contents),
 		"filePath":         filePath,
 		"createRevision":   opt.CreateRevision,
 		"latestRevisionID": opt.LatestRevisionID,
 		"workspaceTool":    g.globalOpts.WorkspaceTool,
-		"env":              g.globalOpts.Env,
+		"env":              g.options().Env,
 	})
 
-	return parsePossibleConflictInWorkspaceError(err)
+	return parseWorkspaceError(err, opt.WorkspaceID, filePath)
 }
 
 type DeleteFileInWorkspaceOptions struct {
@@ -203,14 +294,10 @@ func (g *GPTScript) DeleteFileInWorkspace(ctx context.Context, filePath string,
 		"id":            opt.WorkspaceID,
 		"filePath":      filePath,
 		"workspaceTool": g.globalOpts.WorkspaceTool,
-		"env":           g.globalOpts.Env,
+		"env":           g.options().Env,
 	})
 
-	if err != nil && strings.HasSuffix(err.Error(), fmt.Sprintf("not found: %s/%s", opt.WorkspaceID, filePath)) {
-		return newNotFoundInWorkspaceError(opt.WorkspaceID, filePath)
-	}
-
-	return err
+	return parseWorkspaceError(err, opt.WorkspaceID, filePath)
 }
 
 type ReadFileInWorkspaceOptions struct {
@@ -229,17 +316,14 @@ func (g *GPTScript) ReadFileInWorkspace(ctx context.Context, filePath string, op
 		opt.WorkspaceID = os.Getenv("GPTSCRIPT_WORKSPACE_ID")
 	}
 
-	out, err := g.runBasicCommand(ctx, "workspaces/read-file", map[string]any{
+	out, err := g.runRetryableCommand(ctx, "workspaces/read-file", map[string]any{
 		"id":            opt.WorkspaceID,
 		"filePath":      filePath,
 		"workspaceTool": g.globalOpts.WorkspaceTool,
-		"env":           g.globalOpts.Env,
+		"env":           g.options().Env,
 	})
 	if err != nil {
-		if strings.HasSuffix(err.Error(), fmt.Sprintf("not found: %s/%s", opt.WorkspaceID, filePath)) {
-			return nil, newNotFoundInWorkspaceError(opt.WorkspaceID, filePath)
-		}
-		return nil, err
+		return nil, parseWorkspaceError(err, opt.WorkspaceID, filePath)
 	}
 
 	return base64.StdEncoding.DecodeString(out)
@@ -262,17 +346,14 @@ func (g *GPTScript) ReadFileWithRevisionInWorkspace(ctx context.Context, filePat
 		opt.WorkspaceID = os.Getenv("GPTSCRIPT_WORKSPACE_ID")
 	}
 
-	out, err := g.runBasicCommand(ctx, "workspaces/read-file-with-revision", map[string]any{
+	out, err := g.runRetryableCommand(ctx, "workspaces/read-file-with-revision", map[string]any{
 		"id":            opt.WorkspaceID,
 		"filePath":      filePath,
 		"workspaceTool": g.globalOpts.WorkspaceTool,
-		"env":           g.globalOpts.Env,
+		"env":           g.options().Env,
 	})
 	if err != nil {
-		if strings.HasSuffix(err.Error(), fmt.Sprintf("not found: %s/%s", opt.WorkspaceID, filePath)) {
-			return nil, newNotFoundInWorkspaceError(opt.WorkspaceID, filePath)
-		}
-		return nil, err
+		return nil, parseWorkspaceError(err, opt.WorkspaceID, filePath)
 	}
 
 	var resp ReadFileWithRevisionInWorkspaceResponse
@@ -311,18 +392,15 @@ func (g *GPTScript) StatFileInWorkspace(ctx context.Context, filePath string, op
 		opt.WorkspaceID = os.Getenv("GPTSCRIPT_WORKSPACE_ID")
 	}
 
-	out, err := g.runBasicCommand(ctx, "workspaces/stat-file", map[string]any{
+	out, err := g.runRetryableCommand(ctx, "workspaces/stat-file", map[string]any{
 		"id":                   opt.WorkspaceID,
 		"filePath":             filePath,
 		"withLatestRevisionID": opt.WithLatestRevisionID,
 		"workspaceTool":        g.globalOpts.WorkspaceTool,
-		"env":                  g.globalOpts.Env,
+		"env":                  g.options().Env,
 	})
 	if err != nil {
-		if strings.HasSuffix(err.Error(), fmt.Sprintf("not found: %s/%s", opt.WorkspaceID, filePath)) {
-			return FileInfo{}, newNotFoundInWorkspaceError(opt.WorkspaceID, filePath)
-		}
-		return FileInfo{}, err
+		return FileInfo{}, parseWorkspaceError(err, opt.WorkspaceID, filePath)
 	}
 
 	var info FileInfo
@@ -350,17 +428,14 @@ func (g *GPTScript) ListRevisionsForFileInWorkspace(ctx context.Context, filePat
 		opt.WorkspaceID = os.Getenv("GPTSCRIPT_WORKSPACE_ID")
 	}
 
-	out, err := g.runBasicCommand(ctx, "workspaces/list-revisions", map[string]any{
+	out, err := g.runRetryableCommand(ctx, "workspaces/list-revisions", map[string]any{
 		"id":            opt.WorkspaceID,
 		"filePath":      filePath,
 		"workspaceTool": g.globalOpts.WorkspaceTool,
-		"env":           g.globalOpts.Env,
+		"env":           g.options().Env,
 	})
 	if err != nil {
-		if strings.HasSuffix(err.Error(), fmt.Sprintf("not found: %s/%s", opt.WorkspaceID, filePath)) {
-			return nil, newNotFoundInWorkspaceError(opt.WorkspaceID, filePath)
-		}
-		return nil, err
+		return nil, parseWorkspaceError(err, opt.WorkspaceID, filePath)
 	}
 
 	var info []FileInfo
@@ -388,18 +463,15 @@ func (g *GPTScript) GetRevisionForFileInWorkspace(ctx context.Context, filePath,
 		opt.WorkspaceID = os.Getenv("GPTSCRIPT_WORKSPACE_ID")
 	}
 
-	out, err := g.runBasicCommand(ctx, "workspaces/get-revision", map[string]any{
+	out, err := g.runRetryableCommand(ctx, "workspaces/get-revision", map[string]any{
 		"id":            opt.WorkspaceID,
 		"filePath":      filePath,
 		"revisionID":    revisionID,
 		"workspaceTool": g.globalOpts.WorkspaceTool,
-		"env":           g.globalOpts.Env,
+		"env":           g.options().Env,
 	})
 	if err != nil {
-		if strings.HasSuffix(err.Error(), fmt.Sprintf("not found: %s/%s", opt.WorkspaceID, filePath)) {
-			return nil, newNotFoundInWorkspaceError(opt.WorkspaceID, filePath)
-		}
-		return nil, err
+		return nil, parseWorkspaceError(err, opt.WorkspaceID, filePath)
 	}
 
 	return base64.StdEncoding.DecodeString(out)
@@ -426,10 +498,11 @@ func (g *GPTScript) DeleteRevisionForFileInWorkspace(ctx context.Context, filePa
 		"filePath":      filePath,
 		"revisionID":    revisionID,
 		"workspaceTool": g.globalOpts.WorkspaceTool,
-		"env":           g.globalOpts.Env,
+		"env":           g.options().Env,
 	})
-	if err != nil && strings.HasSuffix(err.Error(), fmt.Sprintf("not found: %s/%s", opt.WorkspaceID, filePath)) {
-		return newNotFoundInWorkspaceError(opt.WorkspaceID, fmt.Sprintf("revision %s for %s", revisionID, filePath))
+	err = parseWorkspaceError(err, opt.WorkspaceID, filePath)
+	if notFoundErr := new(NotFoundInWorkspaceError); errors.As(err, &notFoundErr) {
+		return newNotFoundInWorkspaceError(opt.WorkspaceID, fmt.Sprintf("revision %s for %s", revisionID, filePath), notFoundErr.err)
 	}
 
 	return err